@@ -0,0 +1,339 @@
+package restclient
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	AuthModeBasic  = "basic"
+	AuthModeBearer = "bearer"
+	AuthModeDigest = "digest"
+)
+
+var ErrDigestChallenge = errors.New("invalid digest challenge")
+
+// AuthConfig sets the credentials of the Authorization header of every request. The header is always masked in the har entries handed to the tracer.
+type AuthConfig struct {
+	Mode     string `mapstructure:"mode,omitempty" json:"mode,omitempty" yaml:"mode,omitempty"`
+	Username string `mapstructure:"username,omitempty" json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `mapstructure:"password,omitempty" json:"password,omitempty" yaml:"password,omitempty"`
+	Token    string `mapstructure:"token,omitempty" json:"token,omitempty" yaml:"token,omitempty"`
+	// Scheme of the Authorization header in bearer mode. Defaults to Bearer.
+	Scheme string `mapstructure:"scheme,omitempty" json:"scheme,omitempty" yaml:"scheme,omitempty"`
+}
+
+func (s *Client) setAuth(cfg *AuthConfig) {
+
+	const semLogContext = "http-client::set-auth"
+
+	if cfg == nil || cfg.Mode == "" {
+		return
+	}
+
+	if s.tokenSource != nil {
+		log.Warn().Str("mode", cfg.Mode).Msg(semLogContext + " oauth2 token source configured... auth section ignored")
+		return
+	}
+
+	switch strings.ToLower(cfg.Mode) {
+	case AuthModeBasic:
+		s.restClient.SetBasicAuth(cfg.Username, cfg.Password)
+	case AuthModeBearer:
+		if cfg.Scheme != "" {
+			s.restClient.SetAuthScheme(cfg.Scheme)
+		}
+		s.restClient.SetAuthToken(cfg.Token)
+	case AuthModeDigest:
+		// the transport is wrapped once, at creation: the client transport must not be replaced afterwards.
+		s.restClient.SetTransport(newDigestTransport(cfg.Username, cfg.Password, s.cfg.sharedDigest, s.restClient.GetClient().Transport))
+	default:
+		log.Error().Str("mode", cfg.Mode).Msg(semLogContext + " unsupported auth mode... requests will not be authorized")
+		return
+	}
+
+	s.redactSecretHeaders("Authorization")
+}
+
+// digestTransport implements the HTTP Digest access authentication (RFC 7616). The last challenge received is kept so that subsequent requests
+// are authorized upfront; a 401 with a new or stale nonce gets the request resent once, provided its body can be obtained again.
+type digestTransport struct {
+	username  string
+	password  string
+	transport http.RoundTripper
+	state     *digestState
+}
+
+// digestState is the last challenge received and its nonce count. It is owned by the LinkedService and shared by the clients it creates,
+// so that a new client does not pay a 401 to learn a nonce already known.
+type digestState struct {
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        int
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+func newDigestTransport(username, password string, state *digestState, transport http.RoundTripper) *digestTransport {
+	if state == nil {
+		state = &digestState{}
+	}
+	return &digestTransport{username: username, password: password, transport: transport, state: state}
+}
+
+func (dt *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	authorized := false
+	if auth, err := dt.authorization(req); err == nil && auth != "" {
+		req = cloneRequestWithHeader(req, "Authorization", auth)
+		authorized = true
+	}
+
+	resp, err := dt.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	chal, err := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if err != nil {
+		// not a digest challenge: the 401 is the final answer.
+		return resp, nil
+	}
+
+	if authorized && !chal.stale && chal.nonce == dt.state.currentNonce() {
+		// the credentials themselves have been rejected.
+		return resp, nil
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	dt.state.setChallenge(&chal.digestChallenge)
+	auth, err := dt.authorization(req)
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := cloneRequestWithHeader(req, "Authorization", auth)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return dt.transport.RoundTrip(retry)
+}
+
+func (ds *digestState) currentNonce() string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.challenge == nil {
+		return ""
+	}
+	return ds.challenge.nonce
+}
+
+func (ds *digestState) setChallenge(c *digestChallenge) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.challenge = c
+	ds.nc = 0
+}
+
+// next returns the current challenge along with the nonce count of the request about to be authorized.
+func (ds *digestState) next() (*digestChallenge, int) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.nc++
+	return ds.challenge, ds.nc
+}
+
+// authorization computes the Authorization header for the request out of the current challenge. An empty string is returned if no challenge has been received yet.
+func (dt *digestTransport) authorization(req *http.Request) (string, error) {
+	c, nc := dt.state.next()
+
+	if c == nil {
+		return "", nil
+	}
+
+	algorithm := strings.ToUpper(c.algorithm)
+	newHash := digestHashFunc(algorithm)
+	if newHash == nil {
+		return "", fmt.Errorf("%w: unsupported algorithm %s", ErrDigestChallenge, c.algorithm)
+	}
+
+	h := func(s string) string {
+		hf := newHash()
+		_, _ = io.WriteString(hf, s)
+		return hex.EncodeToString(hf.Sum(nil))
+	}
+
+	cnonceBytes := make([]byte, 16)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	uri := req.URL.RequestURI()
+	ha1 := h(dt.username + ":" + c.realm + ":" + dt.password)
+	if strings.HasSuffix(algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+
+	ncs := fmt.Sprintf("%08x", nc)
+	var response string
+	if c.qop != "" {
+		response = h(strings.Join([]string{ha1, c.nonce, ncs, cnonce, c.qop, ha2}, ":"))
+	} else {
+		response = h(strings.Join([]string{ha1, c.nonce, ha2}, ":"))
+	}
+
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, `Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`, escapeQuotes(dt.username), escapeQuotes(c.realm), c.nonce, uri, response)
+	if c.algorithm != "" {
+		_, _ = fmt.Fprintf(&sb, ", algorithm=%s", c.algorithm)
+	}
+	if c.qop != "" {
+		_, _ = fmt.Fprintf(&sb, `, qop=%s, nc=%s, cnonce="%s"`, c.qop, ncs, cnonce)
+	}
+	if c.opaque != "" {
+		_, _ = fmt.Fprintf(&sb, `, opaque="%s"`, c.opaque)
+	}
+
+	return sb.String(), nil
+}
+
+func digestHashFunc(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "", "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	case "SHA-512-256":
+		return sha512.New512_256
+	}
+
+	return nil
+}
+
+type parsedDigestChallenge struct {
+	digestChallenge
+	stale bool
+}
+
+// parseDigestChallenge looks for the Digest challenge among the WWW-Authenticate header values. Only the 'auth' quality of protection is supported.
+func parseDigestChallenge(values []string) (parsedDigestChallenge, error) {
+
+	for _, v := range values {
+		scheme, params, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(scheme, "digest") {
+			continue
+		}
+
+		var c parsedDigestChallenge
+		for k, pv := range parseAuthParams(params) {
+			switch k {
+			case "realm":
+				c.realm = pv
+			case "nonce":
+				c.nonce = pv
+			case "opaque":
+				c.opaque = pv
+			case "algorithm":
+				c.algorithm = pv
+			case "stale":
+				c.stale = strings.EqualFold(pv, "true")
+			case "qop":
+				for _, q := range strings.Split(pv, ",") {
+					if strings.TrimSpace(q) == "auth" {
+						c.qop = "auth"
+					}
+				}
+				if c.qop == "" {
+					return c, fmt.Errorf("%w: unsupported qop %s", ErrDigestChallenge, pv)
+				}
+			}
+		}
+
+		if c.nonce == "" {
+			return c, fmt.Errorf("%w: missing nonce", ErrDigestChallenge)
+		}
+
+		return c, nil
+	}
+
+	return parsedDigestChallenge{}, fmt.Errorf("%w: no digest challenge", ErrDigestChallenge)
+}
+
+// parseAuthParams parses a comma separated list of name=value pairs where values can be quoted strings containing commas.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					sb.WriteByte(rest[i])
+					continue
+				}
+				if rest[i] == '"' {
+					break
+				}
+				sb.WriteByte(rest[i])
+			}
+			value = sb.String()
+			if i < len(rest) {
+				i++
+			}
+			s = rest[i:]
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+
+		params[name] = value
+	}
+
+	return params
+}
+
+func cloneRequestWithHeader(req *http.Request, name, value string) *http.Request {
+	r2 := req.Clone(req.Context())
+	r2.Header.Set(name, value)
+	return r2
+}
@@ -0,0 +1,137 @@
+package restclient_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAuthBasicAndBearer(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		auth     restclient.AuthConfig
+		expected string
+	}{
+		{auth: restclient.AuthConfig{Mode: restclient.AuthModeBasic, Username: "user", Password: "pwd"}, expected: "Basic dXNlcjpwd2Q="},
+		{auth: restclient.AuthConfig{Mode: restclient.AuthModeBearer, Token: "my-token"}, expected: "Bearer my-token"},
+		{auth: restclient.AuthConfig{Mode: restclient.AuthModeBearer, Token: "my-token", Scheme: "Token"}, expected: "Token my-token"},
+	}
+
+	for _, tc := range testCases {
+		lks, err := restclient.NewInstanceWithConfig(&restclient.Config{Auth: &tc.auth})
+		require.NoError(t, err)
+
+		client, err := lks.NewClient()
+		require.NoError(t, err)
+
+		request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+		require.NoError(t, err)
+		harEntry, err := client.Execute(request)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, string(harEntry.Response.Content.Data))
+
+		// the header set by the client does not end up in the entry; if provided by the caller it gets masked anyway.
+		require.Empty(t, harEntry.Request.Headers.GetFirst("Authorization").Value)
+		harEntry.Request.Headers = append(harEntry.Request.Headers, har.NameValuePair{Name: "Authorization", Value: tc.expected})
+		require.Equal(t, restclient.DefaultRedactionMask, client.RedactEntry(harEntry).Request.Headers.GetFirst("Authorization").Value)
+		client.Close()
+	}
+}
+
+func TestAuthDigest(t *testing.T) {
+
+	const (
+		realm = "test-realm"
+		nonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	)
+
+	ha1 := md5Hex("user:" + realm + ":pwd")
+	paramRe := regexp.MustCompile(`(\w+)="?([^",]*)"?`)
+
+	var challenges, authorized int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			atomic.AddInt32(&challenges, 1)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", qop="auth,auth-int", nonce="%s", opaque="xyz"`, realm, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		params := map[string]string{}
+		for _, m := range paramRe.FindAllStringSubmatch(auth, -1) {
+			params[m[1]] = m[2]
+		}
+
+		ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+		expected := md5Hex(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+		if params["response"] != expected || params["opaque"] != "xyz" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		atomic.AddInt32(&authorized, 1)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{Auth: &restclient.AuthConfig{Mode: restclient.AuthModeDigest, Username: "user", Password: "pwd"}})
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		request, err := client.NewRequest(http.MethodPost, srv.URL+"/resource?id=1", []byte(`{"a":1}`), nil, nil)
+		require.NoError(t, err)
+		harEntry, err := client.Execute(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, harEntry.Response.Status)
+	}
+
+	// the challenge is kept: the second request is authorized upfront.
+	require.Equal(t, int32(1), atomic.LoadInt32(&challenges))
+	require.Equal(t, int32(2), atomic.LoadInt32(&authorized))
+
+	// the clients of a linked service share the challenge: only the first one is challenged.
+	atomic.StoreInt32(&challenges, 0)
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{Auth: &restclient.AuthConfig{Mode: restclient.AuthModeDigest, Username: "user", Password: "pwd"}})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		lksClient, err := lks.NewClient()
+		require.NoError(t, err)
+
+		request, err := lksClient.NewRequest(http.MethodGet, srv.URL+"/resource", nil, nil, nil)
+		require.NoError(t, err)
+		harEntry, err := lksClient.Execute(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, harEntry.Response.Status)
+		lksClient.Close()
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&challenges))
+
+	client = restclient.NewClient(&restclient.Config{Auth: &restclient.AuthConfig{Mode: restclient.AuthModeDigest, Username: "user", Password: "wrong"}})
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, harEntry.Response.Status)
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
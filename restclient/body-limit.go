@@ -0,0 +1,75 @@
+package restclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// bodyLimitKey is the context key of the max size of the response body of a buffered execution.
+type bodyLimitKey struct{}
+
+func withBodyLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, bodyLimitKey{}, limit)
+}
+
+// bodyLimitTransport cuts the response bodies of the buffered executions at the max-body-size of the client: the bytes beyond are not read.
+// The streamed executions are left alone, the caller reads their bodies in full.
+type bodyLimitTransport struct {
+	next http.RoundTripper
+}
+
+func (t *bodyLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if limit, ok := req.Context().Value(bodyLimitKey{}).(int64); ok && limit > 0 && resp.Body != nil {
+		resp.Body = &truncatedBody{body: resp.Body, remaining: limit}
+	}
+
+	return resp, nil
+}
+
+func (t *bodyLimitTransport) CloseIdleConnections() {
+	if ci, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// truncatedBody returns io.EOF once the limit has been reached, telling whether the body went beyond it.
+type truncatedBody struct {
+	body      io.ReadCloser
+	remaining int64
+	truncated bool
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// a single byte more tells whether the body is longer than the limit.
+		var one [1]byte
+		for {
+			n, err := b.body.Read(one[:])
+			if n > 0 {
+				b.truncated = true
+				return 0, io.EOF
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *truncatedBody) Close() error {
+	return b.body.Close()
+}
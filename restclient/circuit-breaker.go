@@ -0,0 +1,293 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitBreakerScopeHost    = "host"
+	CircuitBreakerScopeService = "service"
+
+	CircuitStateClosed   = "closed"
+	CircuitStateOpen     = "open"
+	CircuitStateHalfOpen = "half-open"
+
+	DefaultCircuitBreakerConsecutiveFailures = 5
+	DefaultCircuitBreakerWindow              = 20
+	DefaultCircuitBreakerOpenDuration        = 30 * time.Second
+	DefaultCircuitBreakerHalfOpenProbes      = 1
+)
+
+// circuitOutcome is how an execution counts for the circuit. The neutral outcomes never reached the backend and are not accounted.
+type circuitOutcome int
+
+const (
+	circuitOutcomeSuccess circuitOutcome = iota
+	circuitOutcomeFailure
+	circuitOutcomeNeutral
+)
+
+var (
+	ErrCircuitBreakerConfig = errors.New("invalid circuit breaker config")
+	ErrCircuitOpen          = errors.New("circuit breaker open")
+)
+
+// CircuitBreakerConfig makes the requests fail fast with ErrCircuitOpen when the backend keeps failing. The circuit opens after ConsecutiveFailures
+// failed executions in a row or, if FailureRate is set, when the failed ones reach that rate (0-1) over the last Window executions. After
+// OpenDuration up to HalfOpenProbes executions are let through: the circuit closes if all of them succeed and opens again at the first failure.
+// An execution fails on transport errors and on the FailureStatusCodes (5xx by default), retries included; the ones canceled by the caller or
+// stopped by the rate limiter or the token source before reaching the backend are not accounted. Scope keys the circuits by host, the
+// default, or uses one circuit for the whole service.
+type CircuitBreakerConfig struct {
+	Scope               string        `mapstructure:"scope,omitempty" json:"scope,omitempty" yaml:"scope,omitempty"`
+	ConsecutiveFailures int           `mapstructure:"consecutive-failures,omitempty" json:"consecutive-failures,omitempty" yaml:"consecutive-failures,omitempty"`
+	FailureRate         float64       `mapstructure:"failure-rate,omitempty" json:"failure-rate,omitempty" yaml:"failure-rate,omitempty"`
+	Window              int           `mapstructure:"window,omitempty" json:"window,omitempty" yaml:"window,omitempty"`
+	OpenDuration        time.Duration `mapstructure:"open-duration,omitempty" json:"open-duration,omitempty" yaml:"open-duration,omitempty"`
+	HalfOpenProbes      int           `mapstructure:"half-open-probes,omitempty" json:"half-open-probes,omitempty" yaml:"half-open-probes,omitempty"`
+	FailureStatusCodes  []int         `mapstructure:"failure-status-codes,omitempty" json:"failure-status-codes,omitempty" yaml:"failure-status-codes,omitempty"`
+}
+
+// circuitBreakers holds the circuits of a LinkedService, or of a standalone client, created on first use.
+type circuitBreakers struct {
+	cfg CircuitBreakerConfig
+
+	mu       sync.Mutex
+	circuits map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(cfg *CircuitBreakerConfig) (*circuitBreakers, error) {
+
+	c := *cfg
+	switch c.Scope {
+	case "":
+		c.Scope = CircuitBreakerScopeHost
+	case CircuitBreakerScopeHost, CircuitBreakerScopeService:
+	default:
+		return nil, fmt.Errorf("%w: unsupported scope %s", ErrCircuitBreakerConfig, c.Scope)
+	}
+
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return nil, fmt.Errorf("%w: failure rate %v out of the 0-1 range", ErrCircuitBreakerConfig, c.FailureRate)
+	}
+
+	if c.ConsecutiveFailures == 0 && c.FailureRate == 0 {
+		c.ConsecutiveFailures = DefaultCircuitBreakerConsecutiveFailures
+	}
+
+	if c.Window <= 0 {
+		c.Window = DefaultCircuitBreakerWindow
+	}
+
+	if c.OpenDuration <= 0 {
+		c.OpenDuration = DefaultCircuitBreakerOpenDuration
+	}
+
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = DefaultCircuitBreakerHalfOpenProbes
+	}
+
+	return &circuitBreakers{cfg: c, circuits: make(map[string]*circuitBreaker)}, nil
+}
+
+// circuit returns the circuit of the url. A nil receiver, no circuit breaker configured, returns a nil circuit.
+func (cbs *circuitBreakers) circuit(u string) *circuitBreaker {
+	if cbs == nil {
+		return nil
+	}
+
+	key := CircuitBreakerScopeService
+	if cbs.cfg.Scope == CircuitBreakerScopeHost {
+		if pu, err := url.Parse(u); err == nil {
+			key = pu.Host
+		}
+	}
+
+	cbs.mu.Lock()
+	defer cbs.mu.Unlock()
+
+	cb, ok := cbs.circuits[key]
+	if !ok {
+		cb = &circuitBreaker{key: key, cfg: &cbs.cfg, state: CircuitStateClosed, outcomes: make([]bool, cbs.cfg.Window)}
+		cbs.circuits[key] = cb
+	}
+
+	return cb
+}
+
+type circuitBreaker struct {
+	key string
+	cfg *CircuitBreakerConfig
+
+	mu    sync.Mutex
+	state string
+	// generation changes on every transition: the outcome of an execution admitted in a previous state is discarded.
+	generation  int
+	openedAt    time.Time
+	consecutive int
+
+	// outcomes is the ring of the last executions, true for the failed ones.
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+
+	probes    int
+	successes int
+}
+
+// allow admits an execution returning the generation its outcome has to be recorded with.
+func (cb *circuitBreaker) allow() (int, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitStateOpen {
+		if time.Since(cb.openedAt) < cb.cfg.OpenDuration {
+			return 0, fmt.Errorf("%w: %s", ErrCircuitOpen, cb.key)
+		}
+		cb.transition(CircuitStateHalfOpen)
+	}
+
+	if cb.state == CircuitStateHalfOpen {
+		if cb.probes >= cb.cfg.HalfOpenProbes {
+			return 0, fmt.Errorf("%w: %s, probing", ErrCircuitOpen, cb.key)
+		}
+		cb.probes++
+	}
+
+	return cb.generation, nil
+}
+
+// record accounts the outcome of an execution admitted by allow and returns the state of the circuit before and after it. A neutral outcome
+// gives back its probe slot to the half-open circuit and leaves the counters alone.
+func (cb *circuitBreaker) record(generation int, outcome circuitOutcome) (string, string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	from := cb.state
+	if generation != cb.generation {
+		return from, cb.state
+	}
+
+	if outcome == circuitOutcomeNeutral {
+		if cb.state == CircuitStateHalfOpen && cb.probes > 0 {
+			cb.probes--
+		}
+		return from, cb.state
+	}
+
+	failed := outcome == circuitOutcomeFailure
+
+	switch cb.state {
+	case CircuitStateHalfOpen:
+		if failed {
+			cb.transition(CircuitStateOpen)
+			break
+		}
+
+		cb.successes++
+		if cb.successes >= cb.cfg.HalfOpenProbes {
+			cb.transition(CircuitStateClosed)
+		}
+
+	case CircuitStateClosed:
+		if failed {
+			cb.consecutive++
+		} else {
+			cb.consecutive = 0
+		}
+
+		if cb.count == len(cb.outcomes) && cb.outcomes[cb.next] {
+			cb.failures--
+		}
+		cb.outcomes[cb.next] = failed
+		cb.next = (cb.next + 1) % len(cb.outcomes)
+		if cb.count < len(cb.outcomes) {
+			cb.count++
+		}
+		if failed {
+			cb.failures++
+		}
+
+		if cb.tripped() {
+			cb.transition(CircuitStateOpen)
+		}
+	}
+
+	return from, cb.state
+}
+
+func (cb *circuitBreaker) currentState() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cb *circuitBreaker) tripped() bool {
+	if cb.cfg.ConsecutiveFailures > 0 && cb.consecutive >= cb.cfg.ConsecutiveFailures {
+		return true
+	}
+
+	return cb.cfg.FailureRate > 0 && cb.count == len(cb.outcomes) && float64(cb.failures)/float64(cb.count) >= cb.cfg.FailureRate
+}
+
+// transition moves the circuit to state resetting the counters. Called with the lock held.
+func (cb *circuitBreaker) transition(state string) {
+
+	const semLogContext = "http-client::circuit-breaker"
+
+	evt := log.Info()
+	if state == CircuitStateOpen {
+		evt = log.Warn()
+	}
+	evt.Str("circuit", cb.key).Str("from", cb.state).Str("to", state).Int("consecutive-failures", cb.consecutive).Int("failures", cb.failures).Int("window", cb.count).Msg(semLogContext + " state transition")
+
+	cb.state = state
+	cb.generation++
+	cb.probes = 0
+	cb.successes = 0
+	cb.consecutive = 0
+	cb.failures = 0
+	cb.count = 0
+	cb.next = 0
+	clear(cb.outcomes)
+
+	if state == CircuitStateOpen {
+		cb.openedAt = time.Now()
+	}
+}
+
+// isFailure tells how the outcome of an execution counts for the circuit. The local errors and the requests canceled by the caller are neutral:
+// they say nothing about the backend.
+func (cbs *circuitBreakers) isFailure(resp *resty.Response, err error) circuitOutcome {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, ErrTokenSource) || errors.Is(err, ErrRateLimited) {
+			return circuitOutcomeNeutral
+		}
+		return circuitOutcomeFailure
+	}
+
+	sc := resp.StatusCode()
+	if len(cbs.cfg.FailureStatusCodes) == 0 {
+		if sc >= http.StatusInternalServerError {
+			return circuitOutcomeFailure
+		}
+		return circuitOutcomeSuccess
+	}
+
+	for _, c := range cbs.cfg.FailureStatusCodes {
+		if sc == c {
+			return circuitOutcomeFailure
+		}
+	}
+
+	return circuitOutcomeSuccess
+}
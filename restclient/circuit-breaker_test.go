@@ -0,0 +1,203 @@
+package restclient_test
+
+import (
+	"context"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	var hits int64
+	var status int64 = http.StatusServiceUnavailable
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(int(atomic.LoadInt64(&status)))
+	}))
+	defer down.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{
+		RetryCount:       2,
+		RetryWaitTime:    time.Millisecond,
+		RetryOnHttpError: []int{http.StatusServiceUnavailable},
+		CircuitBreaker:   &restclient.CircuitBreakerConfig{ConsecutiveFailures: 3, OpenDuration: 200 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	execute := func(u string) (int, error) {
+		client, err := lks.NewClient()
+		require.NoError(t, err)
+		defer client.Close()
+
+		request, err := client.NewRequest(http.MethodGet, u, nil, nil, nil)
+		require.NoError(t, err)
+		harEntry, err := client.Execute(request)
+		return harEntry.Response.Status, err
+	}
+
+	lastCircuitState := func() interface{} {
+		spans := tracer.FinishedSpans()
+		return spans[len(spans)-1].Tag(restclient.CircuitStateTraceTag)
+	}
+
+	// the failures of an execution, retries included, count once.
+	for i := 0; i < 3; i++ {
+		sc, err := execute(down.URL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, sc)
+	}
+	require.Equal(t, int64(9), atomic.LoadInt64(&hits))
+	require.Equal(t, restclient.CircuitStateOpen, lastCircuitState())
+
+	// open: the clients of the linked service fail fast, the other hosts are not affected.
+	start := time.Now()
+	sc, err := execute(down.URL)
+	require.True(t, errors.Is(err, restclient.ErrCircuitOpen), err)
+	require.Equal(t, http.StatusServiceUnavailable, sc)
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+	require.Equal(t, int64(9), atomic.LoadInt64(&hits))
+	require.Equal(t, restclient.CircuitStateOpen, lastCircuitState())
+
+	sc, err = execute(up.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, sc)
+	require.Equal(t, restclient.CircuitStateClosed, lastCircuitState())
+
+	// half-open: a failed probe opens the circuit again.
+	time.Sleep(200 * time.Millisecond)
+	_, err = execute(down.URL)
+	require.NoError(t, err)
+	require.Equal(t, restclient.CircuitStateOpen, lastCircuitState())
+	_, err = execute(down.URL)
+	require.True(t, errors.Is(err, restclient.ErrCircuitOpen), err)
+
+	// a successful probe closes it.
+	time.Sleep(200 * time.Millisecond)
+	atomic.StoreInt64(&status, http.StatusOK)
+	sc, err = execute(down.URL)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, sc)
+	require.Equal(t, restclient.CircuitStateClosed, lastCircuitState())
+
+	spans := tracer.FinishedSpans()
+	logs := spans[len(spans)-1].Logs()
+	require.Len(t, logs, 1)
+	fields := make(map[string]string)
+	for _, f := range logs[0].Fields {
+		fields[f.Key] = f.ValueString
+	}
+	require.Equal(t, restclient.CircuitStateHalfOpen, fields["from"])
+	require.Equal(t, restclient.CircuitStateClosed, fields["to"])
+}
+
+func TestCircuitBreakerFailureRate(t *testing.T) {
+
+	var n int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&n, 1)%2 == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{
+		CircuitBreaker: &restclient.CircuitBreakerConfig{Scope: restclient.CircuitBreakerScopeService, FailureRate: 0.5, Window: 4},
+	})
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = client.Execute(request)
+		require.NoError(t, err)
+	}
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = client.Execute(request)
+	require.True(t, errors.Is(err, restclient.ErrCircuitOpen), err)
+
+	_, err = restclient.NewInstanceWithConfig(&restclient.Config{CircuitBreaker: &restclient.CircuitBreakerConfig{Scope: "region"}})
+	require.True(t, errors.Is(err, restclient.ErrCircuitBreakerConfig), err)
+}
+
+func TestCircuitBreakerNeutralOutcomes(t *testing.T) {
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	lastCircuitState := func() interface{} {
+		spans := tracer.FinishedSpans()
+		return spans[len(spans)-1].Tag(restclient.CircuitStateTraceTag)
+	}
+
+	execute := func(client *restclient.Client, ctx context.Context) error {
+		request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = client.ExecuteContext(ctx, request)
+		return err
+	}
+
+	// a probe canceled by the caller neither closes the circuit nor holds its probe slot.
+	client := restclient.NewClient(&restclient.Config{
+		CircuitBreaker: &restclient.CircuitBreakerConfig{ConsecutiveFailures: 1, OpenDuration: 50 * time.Millisecond},
+	})
+	defer client.Close()
+
+	require.NoError(t, execute(client, context.Background()))
+	require.Equal(t, restclient.CircuitStateOpen, lastCircuitState())
+
+	time.Sleep(50 * time.Millisecond)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := execute(client, canceled)
+	require.True(t, errors.Is(err, context.Canceled), err)
+	require.Equal(t, restclient.CircuitStateHalfOpen, lastCircuitState())
+
+	require.NoError(t, execute(client, context.Background()))
+	require.Equal(t, int64(2), atomic.LoadInt64(&hits))
+	require.Equal(t, restclient.CircuitStateOpen, lastCircuitState())
+
+	// a rate limited execution does not reset the consecutive failures.
+	atomic.StoreInt64(&hits, 0)
+	client = restclient.NewClient(&restclient.Config{
+		CircuitBreaker: &restclient.CircuitBreakerConfig{ConsecutiveFailures: 2, OpenDuration: time.Minute},
+		RateLimit:      &restclient.RateLimitConfig{RequestsPerSecond: 10, Burst: 1, Mode: restclient.RateLimitModeFail},
+	})
+	defer client.Close()
+
+	require.NoError(t, execute(client, context.Background()))
+	err = execute(client, context.Background())
+	require.True(t, errors.Is(err, restclient.ErrRateLimited), err)
+	require.Equal(t, restclient.CircuitStateClosed, lastCircuitState())
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, execute(client, context.Background()))
+	require.Equal(t, restclient.CircuitStateOpen, lastCircuitState())
+	err = execute(client, context.Background())
+	require.True(t, errors.Is(err, restclient.ErrCircuitOpen), err)
+	require.Equal(t, int64(2), atomic.LoadInt64(&hits))
+}
@@ -1,8 +1,10 @@
 package restclient
 
 import (
+	"crypto/tls"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/hartracing"
 	"github.com/opentracing/opentracing-go"
+	"net/http"
 	"time"
 )
 
@@ -12,6 +14,13 @@ const (
 	RequestIdTraceTag                    = "req-id"
 	OpNameTraceTag                       = "op-name"
 	LraHttpContextTraceTag               = "long-running-action"
+	ContextErrorTraceTag                 = "context-error"
+	TLSClientCertSerialTraceTag          = "tls-client-cert-serial"
+	ProxyTraceTag                        = "proxy"
+	TimeoutPhaseTraceTag                 = "timeout-phase"
+	HttpVersionTraceTag                  = "http-version"
+	RetryPolicyTraceTag                  = "retry-policy"
+	CircuitStateTraceTag                 = "circuit-state"
 )
 
 type Header struct {
@@ -20,18 +29,51 @@ type Header struct {
 }
 
 type Config struct {
-	RestTimeout       time.Duration    `mapstructure:"timeout,omitempty" json:"timeout,omitempty" yaml:"timeout,omitempty"`
-	SkipVerify        bool             `mapstructure:"skv,omitempty" json:"skv,omitempty" yaml:"skv,omitempty"`
-	Headers           []Header         `mapstructure:"headers,omitempty" json:"headers,omitempty" yaml:"headers,omitempty"`
-	TraceGroupName    string           `mapstructure:"trace-group-name,omitempty" json:"trace-group-name,omitempty" yaml:"trace-group-name,omitempty"`
-	TraceRequestName  string           `mapstructure:"trace-req-name,omitempty" json:"trace-req-name,omitempty" yaml:"trace-req-name,omitempty"`
-	RetryCount        int              `mapstructure:"retry-count,omitempty" json:"retry-count,omitempty" yaml:"retry-count,omitempty"`
-	RetryWaitTime     time.Duration    `mapstructure:"retry-wait-time,omitempty" json:"retry-wait-time,omitempty" yaml:"retry-wait-time,omitempty"`
-	RetryMaxWaitTime  time.Duration    `mapstructure:"retry-max-wait-time,omitempty" json:"retry-max-wait-time,omitempty" yaml:"retry-max-wait-time,omitempty"`
-	RetryOnHttpError  []int            `mapstructure:"retry-on-errors,omitempty" json:"retry-on-errors,omitempty" yaml:"retry-on-errors,omitempty"`
-	HarTracingEnabled bool             `mapstructure:"har-tracing-enabled,omitempty" json:"har-tracing-enabled,omitempty" yaml:"har-tracing-enabled,omitempty"`
-	Span              opentracing.Span `mapstructure:"-" json:"-" yaml:"-"`
-	HarSpan           hartracing.Span  `mapstructure:"-" json:"-" yaml:"-"`
+	RestTimeout       time.Duration         `mapstructure:"timeout,omitempty" json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	SkipVerify        bool                  `mapstructure:"skv,omitempty" json:"skv,omitempty" yaml:"skv,omitempty"`
+	Headers           []Header              `mapstructure:"headers,omitempty" json:"headers,omitempty" yaml:"headers,omitempty"`
+	TraceGroupName    string                `mapstructure:"trace-group-name,omitempty" json:"trace-group-name,omitempty" yaml:"trace-group-name,omitempty"`
+	TraceRequestName  string                `mapstructure:"trace-req-name,omitempty" json:"trace-req-name,omitempty" yaml:"trace-req-name,omitempty"`
+	RetryCount        int                   `mapstructure:"retry-count,omitempty" json:"retry-count,omitempty" yaml:"retry-count,omitempty"`
+	RetryWaitTime     time.Duration         `mapstructure:"retry-wait-time,omitempty" json:"retry-wait-time,omitempty" yaml:"retry-wait-time,omitempty"`
+	RetryMaxWaitTime  time.Duration         `mapstructure:"retry-max-wait-time,omitempty" json:"retry-max-wait-time,omitempty" yaml:"retry-max-wait-time,omitempty"`
+	RetryOnHttpError  []int                 `mapstructure:"retry-on-errors,omitempty" json:"retry-on-errors,omitempty" yaml:"retry-on-errors,omitempty"`
+	HarTracingEnabled bool                  `mapstructure:"har-tracing-enabled,omitempty" json:"har-tracing-enabled,omitempty" yaml:"har-tracing-enabled,omitempty"`
+	CookieJar         *CookieJarConfig      `mapstructure:"cookie-jar,omitempty" json:"cookie-jar,omitempty" yaml:"cookie-jar,omitempty"`
+	MaxBodySize       int64                 `mapstructure:"max-body-size,omitempty" json:"max-body-size,omitempty" yaml:"max-body-size,omitempty"`
+	Redaction         *RedactionConfig      `mapstructure:"redaction,omitempty" json:"redaction,omitempty" yaml:"redaction,omitempty"`
+	OAuth2            *OAuth2Config         `mapstructure:"oauth2,omitempty" json:"oauth2,omitempty" yaml:"oauth2,omitempty"`
+	Auth              *AuthConfig           `mapstructure:"auth,omitempty" json:"auth,omitempty" yaml:"auth,omitempty"`
+	TLS               *TLSConfig            `mapstructure:"tls,omitempty" json:"tls,omitempty" yaml:"tls,omitempty"`
+	Proxy             *ProxyConfig          `mapstructure:"proxy,omitempty" json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	Transport         *TransportConfig      `mapstructure:"transport,omitempty" json:"transport,omitempty" yaml:"transport,omitempty"`
+	Timeouts          *TimeoutsConfig       `mapstructure:"timeouts,omitempty" json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	CircuitBreaker    *CircuitBreakerConfig `mapstructure:"circuit-breaker,omitempty" json:"circuit-breaker,omitempty" yaml:"circuit-breaker,omitempty"`
+	RateLimit         *RateLimitConfig      `mapstructure:"rate-limit,omitempty" json:"rate-limit,omitempty" yaml:"rate-limit,omitempty"`
+	Throttle          *ThrottleConfig       `mapstructure:"throttle,omitempty" json:"throttle,omitempty" yaml:"throttle,omitempty"`
+	Retry             *RetryConfig          `mapstructure:"retry,omitempty" json:"retry,omitempty" yaml:"retry,omitempty"`
+	Idempotency       *IdempotencyConfig    `mapstructure:"idempotency,omitempty" json:"idempotency,omitempty" yaml:"idempotency,omitempty"`
+	Span              opentracing.Span      `mapstructure:"-" json:"-" yaml:"-"`
+	HarSpan           hartracing.Span       `mapstructure:"-" json:"-" yaml:"-"`
+	Jar               http.CookieJar        `mapstructure:"-" json:"-" yaml:"-"`
+	TokenSource       TokenSource           `mapstructure:"-" json:"-" yaml:"-"`
+	TLSClientConfig   *tls.Config           `mapstructure:"-" json:"-" yaml:"-"`
+	HttpTransport     http.RoundTripper     `mapstructure:"-" json:"-" yaml:"-"`
+
+	// sharedTransport is the transport of the LinkedService the client is created from.
+	sharedTransport *transportSetup
+
+	// sharedCircuitBreakers are the circuits of the LinkedService the client is created from.
+	sharedCircuitBreakers *circuitBreakers
+
+	// sharedRateLimiter is the rate limiter of the LinkedService the client is created from.
+	sharedRateLimiter *rateLimiter
+
+	// sharedThrottle is the throttle of the LinkedService the client is created from.
+	sharedThrottle *throttle
+
+	// sharedDigest is the digest challenge of the LinkedService the client is created from.
+	sharedDigest *digestState
 }
 
 func (cfg *Config) IsHarTracingEnabled() bool {
@@ -73,9 +115,11 @@ func WithTraceRequestName(opn string) Option {
 func WithSkipVerify(b bool) Option {
 	return func(o *Config) {
 		o.SkipVerify = b
+		o.sharedTransport = nil
 	}
 }
 
+// WithTimeout bounds each attempt of an execution, the read of the response body included. An execution timeout takes its place.
 func WithTimeout(to time.Duration) Option {
 	return func(o *Config) {
 		o.RestTimeout = to
@@ -105,3 +149,151 @@ func WithRetryCount(to int) Option {
 		o.RetryCount = to
 	}
 }
+
+// WithRetry sets the named retry policies of the client. A default policy replaces the legacy retry settings.
+func WithRetry(r *RetryConfig) Option {
+	return func(o *Config) {
+		o.Retry = r
+	}
+}
+
+// WithIdempotency sets the header of the idempotency key and whether a key is generated for the non idempotent requests lacking one.
+func WithIdempotency(i *IdempotencyConfig) Option {
+	return func(o *Config) {
+		o.Idempotency = i
+	}
+}
+
+// WithCookieJar sets the jar to be used by the client. It takes precedence over the cookie-jar section of the config.
+func WithCookieJar(jar http.CookieJar) Option {
+	return func(o *Config) {
+		o.Jar = jar
+	}
+}
+
+func WithMaxBodySize(sz int64) Option {
+	return func(o *Config) {
+		o.MaxBodySize = sz
+	}
+}
+
+func WithRedaction(r *RedactionConfig) Option {
+	return func(o *Config) {
+		o.Redaction = r
+	}
+}
+
+// WithTokenSource sets the source of the tokens of the Authorization header. It takes precedence over the oauth2 section of the config.
+func WithTokenSource(ts TokenSource) Option {
+	return func(o *Config) {
+		o.TokenSource = ts
+	}
+}
+
+// WithAuth sets the credentials of the client. In digest mode the client keeps a challenge of its own, not shared with the other clients of the LinkedService.
+func WithAuth(a *AuthConfig) Option {
+	return func(o *Config) {
+		o.Auth = a
+		o.sharedDigest = nil
+	}
+}
+
+func WithTLS(t *TLSConfig) Option {
+	return func(o *Config) {
+		o.TLS = t
+		o.sharedTransport = nil
+	}
+}
+
+// WithTLSClientConfig sets the tls config of the client as is. It takes precedence over the tls section of the config and over skv.
+func WithTLSClientConfig(t *tls.Config) Option {
+	return func(o *Config) {
+		o.TLSClientConfig = t
+		o.sharedTransport = nil
+	}
+}
+
+func WithProxy(p *ProxyConfig) Option {
+	return func(o *Config) {
+		o.Proxy = p
+		o.sharedTransport = nil
+	}
+}
+
+// WithTransport tunes the connection pool of the client. Like the other options affecting the transport (skv, tls, proxy) it makes a client
+// created by a LinkedService use a pool of its own instead of the shared one.
+func WithTransport(t *TransportConfig) Option {
+	return func(o *Config) {
+		o.Transport = t
+		o.sharedTransport = nil
+	}
+}
+
+func WithTimeouts(t *TimeoutsConfig) Option {
+	return func(o *Config) {
+		o.Timeouts = t
+		o.sharedTransport = nil
+	}
+}
+
+// WithHttpTransport sets the round tripper of the client as is: the transport, tls and proxy sections of the config are not applied.
+func WithHttpTransport(rt http.RoundTripper) Option {
+	return func(o *Config) {
+		o.HttpTransport = rt
+		o.sharedTransport = nil
+	}
+}
+
+func withSharedTransport(ts *transportSetup) Option {
+	return func(o *Config) {
+		o.sharedTransport = ts
+	}
+}
+
+// WithCircuitBreaker gives the client circuits of its own, not shared with the other clients of the LinkedService.
+func WithCircuitBreaker(cb *CircuitBreakerConfig) Option {
+	return func(o *Config) {
+		o.CircuitBreaker = cb
+		o.sharedCircuitBreakers = nil
+	}
+}
+
+func withSharedCircuitBreakers(cbs *circuitBreakers) Option {
+	return func(o *Config) {
+		o.sharedCircuitBreakers = cbs
+	}
+}
+
+// WithRateLimit gives the client a rate limiter of its own, not shared with the other clients of the LinkedService.
+func WithRateLimit(rl *RateLimitConfig) Option {
+	return func(o *Config) {
+		o.RateLimit = rl
+		o.sharedRateLimiter = nil
+	}
+}
+
+func withSharedRateLimiter(rl *rateLimiter) Option {
+	return func(o *Config) {
+		o.sharedRateLimiter = rl
+	}
+}
+
+// WithThrottle gives the client a throttle of its own, not shared with the other clients of the LinkedService.
+func WithThrottle(t *ThrottleConfig) Option {
+	return func(o *Config) {
+		o.Throttle = t
+		o.sharedThrottle = nil
+	}
+}
+
+func withSharedThrottle(t *throttle) Option {
+	return func(o *Config) {
+		o.sharedThrottle = t
+	}
+}
+
+func withSharedDigest(ds *digestState) Option {
+	return func(o *Config) {
+		o.sharedDigest = ds
+	}
+}
@@ -0,0 +1,233 @@
+package restclient
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/publicsuffix"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const harCookieExpiresLayout = "2006-01-02T15:04:05.000Z07:00"
+
+type CookieJarConfig struct {
+	Enabled bool `mapstructure:"enabled,omitempty" json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Shared makes every Client created from the same LinkedService use the same jar. Otherwise each Client gets its own, unless a FileName is set.
+	Shared bool `mapstructure:"shared,omitempty" json:"shared,omitempty" yaml:"shared,omitempty"`
+	// FileName, if set, is where the cookies get persisted to and loaded from. The jars configured with the same file, shared or not, are one and
+	// the same in the process: separate jars would overwrite each other's cookies when saving.
+	FileName string `mapstructure:"file-name,omitempty" json:"file-name,omitempty" yaml:"file-name,omitempty"`
+}
+
+// CookieJar is an in-memory http.CookieJar, based on the standard library one, that optionally persists its content to a json file.
+type CookieJar struct {
+	mu       sync.Mutex
+	jar      *cookiejar.Jar
+	fileName string
+	cookies  map[string]persistedCookie
+}
+
+type persistedCookie struct {
+	URL    string       `json:"url"`
+	Cookie *http.Cookie `json:"cookie"`
+}
+
+var (
+	fileCookieJarsMu sync.Mutex
+	fileCookieJars   = make(map[string]*CookieJar)
+)
+
+// cookieJarForFile returns the jar persisted to fileName, loaded on first use and then shared by all the clients configured with the same file.
+func cookieJarForFile(fileName string) (*CookieJar, error) {
+	if fileName == "" {
+		return NewCookieJar("")
+	}
+
+	key := fileName
+	if abs, err := filepath.Abs(fileName); err == nil {
+		key = abs
+	}
+
+	fileCookieJarsMu.Lock()
+	defer fileCookieJarsMu.Unlock()
+
+	if cj, ok := fileCookieJars[key]; ok {
+		return cj, nil
+	}
+
+	cj, err := NewCookieJar(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	fileCookieJars[key] = cj
+	return cj, nil
+}
+
+// NewCookieJar creates a jar loading the cookies persisted to fileName, if any. The jar configured in a Config is obtained through the file name
+// instead, so that the clients persisting to the same file share it.
+func NewCookieJar(fileName string) (*CookieJar, error) {
+
+	const semLogContext = "http-client::new-cookie-jar"
+
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+
+	cj := &CookieJar{jar: jar, fileName: fileName, cookies: make(map[string]persistedCookie)}
+	if fileName == "" {
+		return cj, nil
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Info().Str("file-name", fileName).Msg(semLogContext + " cookie file not found... starting with an empty jar")
+			return cj, nil
+		}
+		return nil, err
+	}
+
+	var persisted []persistedCookie
+	if err = json.Unmarshal(b, &persisted); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, pc := range persisted {
+		if pc.Cookie == nil || (!pc.Cookie.Expires.IsZero() && pc.Cookie.Expires.Before(now)) {
+			continue
+		}
+
+		u, err := url.Parse(pc.URL)
+		if err != nil {
+			log.Warn().Err(err).Str("url", pc.URL).Msg(semLogContext + " skipping cookie with invalid url")
+			continue
+		}
+
+		cj.jar.SetCookies(u, []*http.Cookie{pc.Cookie})
+		cj.cookies[cookieKey(u, pc.Cookie)] = pc
+	}
+
+	log.Trace().Str("file-name", fileName).Int("num-cookies", len(cj.cookies)).Msg(semLogContext)
+	return cj, nil
+}
+
+func (cj *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+
+	const semLogContext = "http-client::cookie-jar-set-cookies"
+
+	cj.jar.SetCookies(u, cookies)
+	if cj.fileName == "" || len(cookies) == 0 {
+		return
+	}
+
+	cj.mu.Lock()
+	defer cj.mu.Unlock()
+
+	// The url is stripped of the query part that is not relevant to cookies.
+	origin := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	for _, c := range cookies {
+		k := cookieKey(u, c)
+		if c.MaxAge < 0 || (!c.Expires.IsZero() && c.Expires.Before(time.Now())) {
+			delete(cj.cookies, k)
+			continue
+		}
+
+		pc := persistedCookie{URL: origin.String(), Cookie: c}
+		if c.MaxAge > 0 {
+			// max-age is relative to the moment the cookie is received: it gets converted to an absolute expiry to survive a reload.
+			cc := *c
+			cc.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+			cc.MaxAge = 0
+			pc.Cookie = &cc
+		}
+		cj.cookies[k] = pc
+	}
+
+	if err := cj.save(); err != nil {
+		log.Error().Err(err).Str("file-name", cj.fileName).Msg(semLogContext)
+	}
+}
+
+func (cj *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return cj.jar.Cookies(u)
+}
+
+func (cj *CookieJar) save() error {
+	persisted := make([]persistedCookie, 0, len(cj.cookies))
+	for _, pc := range cj.cookies {
+		persisted = append(persisted, pc)
+	}
+
+	b, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(cj.fileName, b, 0600)
+}
+
+func cookieKey(u *url.URL, c *http.Cookie) string {
+	domain := c.Domain
+	if domain == "" {
+		domain = u.Hostname()
+	}
+	return strings.Join([]string{strings.ToLower(domain), c.Path, c.Name}, ";")
+}
+
+func harCookies(cookies []*http.Cookie) []har.Cookie {
+	hcs := make([]har.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		hc := har.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+
+		switch {
+		case c.MaxAge > 0:
+			hc.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second).Format(harCookieExpiresLayout)
+		case !c.Expires.IsZero():
+			hc.Expires = c.Expires.Format(harCookieExpiresLayout)
+		}
+
+		hcs = append(hcs, hc)
+	}
+
+	return hcs
+}
+
+// harRequestCookies extracts the cookies from the Cookie headers of a request definition.
+func harRequestCookies(headers []har.NameValuePair) []har.Cookie {
+
+	const semLogContext = "http-client::har-request-cookies"
+
+	var cookies []*http.Cookie
+	for _, h := range headers {
+		if strings.ToLower(h.Name) != "cookie" {
+			continue
+		}
+
+		cs, err := http.ParseCookie(h.Value)
+		if err != nil {
+			log.Warn().Err(err).Str("cookie", h.Value).Msg(semLogContext + " invalid cookie header")
+			continue
+		}
+		cookies = append(cookies, cs...)
+	}
+
+	return harCookies(cookies)
+}
@@ -0,0 +1,91 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestCookieJar(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", MaxAge: 3600, HttpOnly: true})
+			return
+		}
+
+		c, err := r.Cookie("session")
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(c.Value))
+	}))
+	defer srv.Close()
+
+	cfg := restclient.Config{CookieJar: &restclient.CookieJarConfig{Enabled: true, Shared: true, FileName: filepath.Join(t.TempDir(), "cookies.json")}}
+	lks, err := restclient.NewInstanceWithConfig(&cfg)
+	require.NoError(t, err)
+
+	client, err := lks.NewClient()
+	require.NoError(t, err)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL+"/login", nil, nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Len(t, harEntry.Response.Cookies, 1)
+	require.Equal(t, "session", harEntry.Response.Cookies[0].Name)
+	require.True(t, harEntry.Response.Cookies[0].HTTPOnly)
+	require.NotEmpty(t, harEntry.Response.Cookies[0].Expires)
+
+	// a brand new linked service gets the session through the file.
+	lks, err = restclient.NewInstanceWithConfig(&cfg)
+	require.NoError(t, err)
+	client2, err := lks.NewClient()
+	require.NoError(t, err)
+	defer client2.Close()
+
+	request, err = client2.NewRequest(http.MethodGet, srv.URL+"/me", nil, nil, nil)
+	require.NoError(t, err)
+	harEntry, err = client2.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, harEntry.Response.Status)
+	require.Equal(t, "abc", string(harEntry.Response.Content.Data))
+	require.Len(t, harEntry.Request.Cookies, 1)
+	require.Equal(t, "abc", harEntry.Request.Cookies[0].Value)
+}
+
+func TestCookieJarSameFile(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		http.SetCookie(w, &http.Cookie{Name: name, Value: name, Path: "/", MaxAge: 3600})
+	}))
+	defer srv.Close()
+
+	// the separate jars of two clients, both created before any cookie is saved, are persisted to the same file.
+	fileName := filepath.Join(t.TempDir(), "cookies.json")
+	cfg := restclient.Config{CookieJar: &restclient.CookieJarConfig{Enabled: true, FileName: fileName}}
+	clients := []*restclient.Client{restclient.NewClient(&cfg), restclient.NewClient(&cfg)}
+	for i, name := range []string{"a", "b"} {
+		defer clients[i].Close()
+
+		request, err := clients[i].NewRequest(http.MethodGet, srv.URL+"?name="+name, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = clients[i].Execute(request)
+		require.NoError(t, err)
+	}
+
+	// the file keeps the cookies of both.
+	jar, err := restclient.NewCookieJar(fileName)
+	require.NoError(t, err)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	require.Len(t, jar.Cookies(u), 2)
+}
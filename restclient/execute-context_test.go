@@ -0,0 +1,43 @@
+package restclient_test
+
+import (
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExecuteContext(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{RetryCount: 3})
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	harEntry, err := client.ExecuteContext(ctx, request)
+	require.Error(t, err)
+	require.Equal(t, http.StatusRequestTimeout, harEntry.Response.Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), harEntry.Response.Comment)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	harEntry, err = client.ExecuteContext(ctx, request)
+	require.Error(t, err)
+	require.Equal(t, restclient.StatusClientClosedRequest, harEntry.Response.Status)
+	require.Equal(t, context.Canceled.Error(), harEntry.Response.Comment)
+}
@@ -0,0 +1,60 @@
+package restclient_test
+
+import (
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExecuteMethods(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Body", string(b))
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(nil)
+	defer client.Close()
+
+	for _, m := range []string{http.MethodOptions, http.MethodHead, http.MethodTrace, "PROPFIND", "REPORT", http.MethodGet} {
+		request, err := client.NewRequest(m, srv.URL, []byte("<propfind/>"), nil, nil)
+		require.NoError(t, err)
+
+		harEntry, err := client.Execute(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, harEntry.Response.Status)
+		require.Equal(t, m, harEntry.Response.Headers.GetFirst("X-Method").Value)
+
+		// the body recorded in the entry is the one the server got.
+		require.Equal(t, "<propfind/>", harEntry.Response.Headers.GetFirst("X-Body").Value)
+		require.Equal(t, "<propfind/>", string(harEntry.Request.PostData.Data))
+		if m != http.MethodHead {
+			require.Equal(t, "<propfind/>", string(harEntry.Response.Content.Data))
+		}
+	}
+
+	// a streamed body as well.
+	request, err := client.NewRequest(http.MethodOptions, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+
+	harEntry, err := client.Execute(request, restclient.ExecutionWithBodyReader(strings.NewReader("<options/>")))
+	require.NoError(t, err)
+	require.Equal(t, "<options/>", harEntry.Response.Headers.GetFirst("X-Body").Value)
+	require.Equal(t, "<options/>", string(harEntry.Request.PostData.Data))
+
+	request, err = client.NewRequest("BAD METHOD", srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+
+	harEntry, err = client.Execute(request)
+	require.Error(t, err)
+	require.True(t, errors.Is(err, restclient.ErrInvalidMethod))
+	require.Equal(t, http.StatusBadRequest, harEntry.Response.Status)
+}
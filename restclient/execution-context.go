@@ -3,6 +3,8 @@ package restclient
 import (
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/hartracing"
 	"github.com/opentracing/opentracing-go"
+	"io"
+	"time"
 )
 
 type ExecutionContext struct {
@@ -11,6 +13,12 @@ type ExecutionContext struct {
 	LRAId     string           `yaml:"lra-id,omitempty" mapstructure:"lra-id,omitempty" json:"lra-id,omitempty"`
 	Span      opentracing.Span `yaml:"-" mapstructure:"-" json:"-"`
 	HarSpan   hartracing.Span  `yaml:"-" mapstructure:"-" json:"-"`
+	Body      BodyFactory      `yaml:"-" mapstructure:"-" json:"-"`
+	// Timeout bounds the whole execution, retries and, for a stream, the read of the response body included. It takes the place of the per
+	// attempt timeout of the client, that it can shorten as well as extend.
+	Timeout time.Duration `yaml:"timeout,omitempty" mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	// IdempotencyKey is sent in the idempotency key header of a request with a non idempotent method, making it retryable.
+	IdempotencyKey string `yaml:"idempotency-key,omitempty" mapstructure:"idempotency-key,omitempty" json:"idempotency-key,omitempty"`
 }
 
 type ExecutionContextOption func(*ExecutionContext)
@@ -44,3 +52,30 @@ func ExecutionWithHarSpan(span hartracing.Span) ExecutionContextOption {
 		ctx.HarSpan = span
 	}
 }
+
+// ExecutionWithBodyFactory streams the body provided by the factory in place of the post data of the request. The factory is invoked again
+// for each retry.
+func ExecutionWithBodyFactory(f BodyFactory) ExecutionContextOption {
+	return func(ctx *ExecutionContext) {
+		ctx.Body = f
+	}
+}
+
+func ExecutionWithBodyReader(r io.Reader) ExecutionContextOption {
+	return func(ctx *ExecutionContext) {
+		ctx.Body = BodyFromReader(r)
+	}
+}
+
+// ExecutionWithTimeout bounds the whole execution, retries included, in place of the timeout of each attempt set on the client.
+func ExecutionWithTimeout(to time.Duration) ExecutionContextOption {
+	return func(ctx *ExecutionContext) {
+		ctx.Timeout = to
+	}
+}
+
+func ExecutionWithIdempotencyKey(key string) ExecutionContextOption {
+	return func(ctx *ExecutionContext) {
+		ctx.IdempotencyKey = key
+	}
+}
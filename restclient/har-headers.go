@@ -0,0 +1,87 @@
+package restclient
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// harHeaders converts an http.Header in a list of name value pairs with one pair per value. The values of a given header
+// keep the order they had on the wire; since http.Header does not retain the relative order of different names, these are sorted
+// to get a deterministic output.
+func harHeaders(h http.Header) har.NameValuePairs {
+
+	names := make([]string, 0, len(h))
+	for n := range h {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	nvs := make(har.NameValuePairs, 0, len(h))
+	for _, n := range names {
+		for _, v := range h[n] {
+			nvs = append(nvs, har.NameValuePair{Name: n, Value: v})
+		}
+	}
+
+	return nvs
+}
+
+// headerLinesSize computes the size of the 'name: value CRLF' lines.
+func headerLinesSize(h http.Header) int {
+	sz := 0
+	for n, vs := range h {
+		for _, v := range vs {
+			sz += len(n) + len(": ") + len(v) + len("\r\n")
+		}
+	}
+
+	return sz
+}
+
+// requestHeadersSize computes the size of the request message up to and including the empty line before the body, as it is
+// written by the http.Transport on an HTTP/1.x connection. Header blocks sent over HTTP/2 are compressed and not available: -1.
+func requestHeadersSize(req *http.Request, proto int) int64 {
+	if req == nil || proto >= 2 {
+		return -1
+	}
+
+	// request line: METHOD SP request-target SP HTTP/1.1 CRLF
+	sz := len(req.Method) + 1 + len(req.URL.RequestURI()) + 1 + len("HTTP/1.1") + len("\r\n")
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	sz += len("Host: ") + len(host) + len("\r\n")
+
+	if req.ContentLength > 0 && req.Header.Get("Content-Length") == "" {
+		sz += len("Content-Length: ") + len(strconv.FormatInt(req.ContentLength, 10)) + len("\r\n")
+	}
+
+	sz += headerLinesSize(req.Header) + len("\r\n")
+	return int64(sz)
+}
+
+// responseHeadersSize is the response counterpart of requestHeadersSize.
+func responseHeadersSize(resp *http.Response) int {
+	if resp == nil || resp.ProtoMajor >= 2 {
+		return -1
+	}
+
+	// status line: HTTP/1.1 SP 200 OK CRLF
+	sz := len(resp.Proto) + 1 + len(resp.Status) + len("\r\n")
+	sz += headerLinesSize(resp.Header) + len("\r\n")
+	return sz
+}
+
+// responseBodySize returns the number of bytes received on the wire: when the body was encoded (i.e. gzip) and the transport did not
+// decode it transparently, the wire size is the content-length and not the size of the decoded content.
+func responseBodySize(resp *http.Response, contentSize int64) int64 {
+	if resp == nil || resp.Uncompressed || resp.ContentLength < 0 || resp.Header.Get("Content-Encoding") == "" {
+		return contentSize
+	}
+
+	return resp.ContentLength
+}
@@ -0,0 +1,48 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHarHeaders(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Accept-Encoding")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(nil)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodPost, srv.URL+"/path?q=1", []byte("{}"), []har.NameValuePair{{Name: "Accept", Value: "text/plain"}}, nil)
+	require.NoError(t, err)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+
+	var cookies, vary []string
+	for _, h := range harEntry.Response.Headers {
+		switch h.Name {
+		case "Set-Cookie":
+			cookies = append(cookies, h.Value)
+		case "Vary":
+			vary = append(vary, h.Value)
+		}
+	}
+	require.Equal(t, []string{"a=1", "b=2"}, cookies)
+	require.Equal(t, []string{"Accept", "Accept-Encoding"}, vary)
+
+	require.Greater(t, harEntry.Response.HeadersSize, len("HTTP/1.1 200 OK\r\n\r\n"))
+	require.Equal(t, int64(5), harEntry.Response.BodySize)
+	require.Greater(t, harEntry.Request.HeadersSize, int64(len("POST /path?q=1 HTTP/1.1\r\n\r\n")))
+	require.Equal(t, int64(2), harEntry.Request.BodySize)
+}
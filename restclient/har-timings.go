@@ -0,0 +1,263 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// harTimingsTrace collects the httptrace events of a request in order to fill in the har.Timings of the entry.
+// In case of retries the events of the last attempt win: the time spent in the previous ones ends up in the blocked phase.
+type harTimingsTrace struct {
+	mu sync.Mutex
+	harTimingsEvents
+
+	// blocked is the time spent waiting for the rate limiter, all the attempts included.
+	blocked time.Duration
+}
+
+type harTimingsEvents struct {
+	getConn      time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteHeaders time.Time
+	wait100      time.Time
+	got100       time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	done         time.Time
+
+	remoteAddr net.Addr
+	localAddr  net.Addr
+	// certSerial is the serial of the client certificate presented on the connection, if known.
+	certSerial string
+}
+
+func (t *harTimingsTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(_ string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.harTimingsEvents = harTimingsEvents{getConn: time.Now()}
+		},
+		DNSStart: func(_ httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(_ httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsDone = time.Now()
+		},
+		ConnectStart: func(_, _ string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			// with multiple addresses (happy eyeballs) the first attempt marks the start of the phase.
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.connectDone = time.Now()
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if err == nil {
+				t.tlsDone = time.Now()
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.gotConn = time.Now()
+			if info.Conn != nil {
+				t.remoteAddr = info.Conn.RemoteAddr()
+				t.localAddr = info.Conn.LocalAddr()
+				t.certSerial = clientCertSerial(info.Conn)
+			}
+		},
+		WroteHeaders: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wroteHeaders = time.Now()
+		},
+		Wait100Continue: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wait100 = time.Now()
+		},
+		Got100Continue: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.got100 = time.Now()
+		},
+		WroteRequest: func(_ httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.firstByte = time.Now()
+		},
+	}
+}
+
+// finish marks the end of the body receive phase.
+func (t *harTimingsTrace) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = time.Now()
+}
+
+// pendingPhase is the phase of the last attempt that was in progress, used to tell where a timeout hit.
+func (t *harTimingsTrace) pendingPhase() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case !t.firstByte.IsZero():
+		return TimeoutPhaseBody
+	case !t.wroteRequest.IsZero():
+		return TimeoutPhaseResponseHeader
+	case !t.wait100.IsZero() && t.got100.IsZero():
+		return TimeoutPhaseExpectContinue
+	case !t.gotConn.IsZero():
+		return TimeoutPhaseSend
+	case !t.tlsStart.IsZero() || !t.connectDone.IsZero():
+		return TimeoutPhaseTLSHandshake
+	case !t.connectStart.IsZero():
+		return TimeoutPhaseDial
+	case !t.dnsStart.IsZero():
+		return TimeoutPhaseDNS
+	}
+
+	return TimeoutPhaseConnection
+}
+
+// harTimings computes the phases of the request. Phases that did not apply (i.e. dns and connect on a reused connection) are set to -1.
+// If no connection has been obtained at all the whole elapsed goes in the wait phase as a fallback.
+func (t *harTimingsTrace) harTimings(elapsed time.Duration) *har.Timings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings := &har.Timings{Blocked: -1, DNS: -1, Connect: -1, Send: -1, Wait: -1, Receive: -1, Ssl: -1}
+	if t.gotConn.IsZero() || t.wroteRequest.IsZero() || t.firstByte.IsZero() {
+		timings.Wait = millis(elapsed)
+		if t.blocked > 0 {
+			timings.Blocked = millis(t.blocked)
+			timings.Wait = millis(max(elapsed-t.blocked, 0))
+		}
+		return timings
+	}
+
+	var accounted time.Duration
+	if !t.dnsStart.IsZero() && !t.dnsDone.IsZero() {
+		timings.DNS = millis(t.dnsDone.Sub(t.dnsStart))
+		accounted += t.dnsDone.Sub(t.dnsStart)
+	}
+
+	if !t.connectStart.IsZero() && !t.connectDone.IsZero() {
+		// As per spec the ssl time is included in the connect one.
+		connectEnd := t.connectDone
+		if !t.tlsStart.IsZero() && !t.tlsDone.IsZero() {
+			timings.Ssl = millis(t.tlsDone.Sub(t.tlsStart))
+			if t.tlsDone.After(connectEnd) {
+				connectEnd = t.tlsDone
+			}
+		}
+		timings.Connect = millis(connectEnd.Sub(t.connectStart))
+		accounted += connectEnd.Sub(t.connectStart)
+	}
+
+	send := t.wroteRequest.Sub(t.gotConn)
+	wait := t.firstByte.Sub(t.wroteRequest)
+	receive := time.Duration(0)
+	if !t.done.IsZero() {
+		receive = t.done.Sub(t.firstByte)
+	}
+
+	timings.Send = millis(send)
+	timings.Wait = millis(wait)
+	timings.Receive = millis(receive)
+	accounted += send + wait + receive
+
+	blocked := elapsed - accounted
+	if blocked < 0 {
+		blocked = 0
+	}
+	timings.Blocked = millis(blocked)
+
+	return timings
+}
+
+func (t *harTimingsTrace) addBlocked(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.blocked += d
+}
+
+func (t *harTimingsTrace) serverIPAddress() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.remoteAddr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(t.remoteAddr.String())
+	if err != nil {
+		return t.remoteAddr.String()
+	}
+
+	return host
+}
+
+// clientCertSerial returns the serial of the client certificate presented on the connection used, empty if none or not known.
+func (t *harTimingsTrace) clientCertSerial() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.certSerial
+}
+
+// connection returns the client port of the connection used, as suggested by the HAR spec.
+func (t *harTimingsTrace) connection() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.localAddr == nil {
+		return ""
+	}
+
+	_, port, err := net.SplitHostPort(t.localAddr.String())
+	if err != nil {
+		return t.localAddr.String()
+	}
+
+	return port
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
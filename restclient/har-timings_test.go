@@ -0,0 +1,45 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHarTimings(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{SkipVerify: true})
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	t.Logf("%+v", *harEntry.Timings)
+
+	require.Equal(t, "127.0.0.1", harEntry.ServerIPAddress)
+	require.NotEmpty(t, harEntry.Connection)
+	require.GreaterOrEqual(t, harEntry.Timings.Ssl, 0.0)
+	require.GreaterOrEqual(t, harEntry.Timings.Connect, harEntry.Timings.Ssl)
+	require.GreaterOrEqual(t, harEntry.Timings.Wait, 20.0)
+	require.GreaterOrEqual(t, harEntry.Timings.Send, 0.0)
+	require.GreaterOrEqual(t, harEntry.Timings.Receive, 0.0)
+
+	// The second round trip goes over the same keep-alive connection: no connect phase.
+	connection := harEntry.Connection
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, connection, harEntry.Connection)
+	require.Equal(t, -1.0, harEntry.Timings.Connect)
+	require.Equal(t, -1.0, harEntry.Timings.Ssl)
+}
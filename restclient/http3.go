@@ -0,0 +1,157 @@
+package restclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	ProtocolHTTP3 = "http3"
+
+	// DefaultHTTP3BrokenDuration is how long a host that could not be reached over QUIC is served by the tcp transport only.
+	DefaultHTTP3BrokenDuration = 5 * time.Minute
+)
+
+// http3DialError marks the errors occurred establishing the QUIC connection: the request has not been sent and can go over tcp.
+type http3DialError struct {
+	err error
+}
+
+func (e *http3DialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *http3DialError) Unwrap() error {
+	return e.err
+}
+
+// http3Transport sends the https requests over HTTP/3 and falls back to the tcp transport (HTTP/2 or HTTP/1.1) when the QUIC connection
+// cannot be established. Plain http requests and the ones going through a proxy use the tcp transport directly.
+type http3Transport struct {
+	h3  *http3.Transport
+	tcp *http.Transport
+
+	mu     sync.Mutex
+	broken map[string]time.Time
+}
+
+func newHttp3Transport(tcp *http.Transport, handshakeTimeout time.Duration, r *certReloader) *http3Transport {
+	t := &http3Transport{tcp: tcp, broken: make(map[string]time.Time)}
+	t.h3 = &http3.Transport{
+		TLSClientConfig: tcp.TLSClientConfig,
+		QUICConfig:      &quic.Config{HandshakeIdleTimeout: handshakeTimeout},
+		Dial:            dialQUIC,
+	}
+
+	// the config of the dial carries the server name, defaulted to the host of the request: the reloadable CAs verify against it.
+	if r != nil && r.verifyCAs {
+		t.h3.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			return dialQUIC(ctx, addr, r.configForHost(tlsCfg, ""), cfg)
+		}
+	}
+
+	return t
+}
+
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	const semLogContext = "http-client::http3-round-trip"
+
+	if req.URL.Scheme != "https" || t.isBroken(req.URL.Host) || t.viaProxy(req) {
+		return t.tcp.RoundTrip(req)
+	}
+
+	// the body is closed by the QUIC transport on error: the fallback needs a new one.
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	resp, err := t.h3.RoundTrip(req)
+
+	var dialErr *http3DialError
+	if err == nil || !errors.As(err, &dialErr) || req.Context().Err() != nil || (hasBody && req.GetBody == nil) {
+		return resp, err
+	}
+
+	log.Warn().Err(err).Str("host", req.URL.Host).Msg(semLogContext + " QUIC connection failed... falling back to tcp")
+	t.setBroken(req.URL.Host)
+
+	if hasBody {
+		r2 := req.Clone(req.Context())
+		if r2.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+		req = r2
+	}
+
+	return t.tcp.RoundTrip(req)
+}
+
+func (t *http3Transport) CloseIdleConnections() {
+	t.tcp.CloseIdleConnections()
+	t.h3.CloseIdleConnections()
+}
+
+func (t *http3Transport) isBroken(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until, ok := t.broken[host]
+	if ok && time.Now().After(until) {
+		delete(t.broken, host)
+		return false
+	}
+
+	return ok
+}
+
+func (t *http3Transport) setBroken(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.broken[host] = time.Now().Add(DefaultHTTP3BrokenDuration)
+}
+
+func (t *http3Transport) viaProxy(req *http.Request) bool {
+	if t.tcp.Proxy == nil {
+		return false
+	}
+
+	p, err := t.tcp.Proxy(req)
+	return err == nil && p != nil
+}
+
+// dialQUIC establishes the QUIC connection reporting the connect and tls phases to the client trace of the request, as the tcp transport does.
+func dialQUIC(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("udp", addr)
+	}
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+
+	conn, err := quic.DialAddr(ctx, addr, tlsCfg, cfg)
+
+	var state tls.ConnectionState
+	if conn != nil {
+		state = conn.ConnectionState().TLS
+	}
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(state, err)
+	}
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("udp", addr, err)
+	}
+
+	if err != nil {
+		return nil, &http3DialError{err: err}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,98 @@
+package restclient_test
+
+import (
+	"crypto/tls"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func echoProtoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.Proto + " " + string(b)))
+	})
+}
+
+func newHttp3Client(t *testing.T) *restclient.Client {
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{
+		SkipVerify: true,
+		Transport:  &restclient.TransportConfig{Protocols: []string{restclient.ProtocolHTTP3}},
+		Timeouts:   &restclient.TimeoutsConfig{TLSHandshake: 300 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	client, err := lks.NewClient()
+	require.NoError(t, err)
+	return client
+}
+
+func TestHttp3(t *testing.T) {
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "server", "localhost")
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}),
+		Handler:   echoProtoHandler(),
+	}
+	go func() { _ = srv.Serve(conn) }()
+	defer srv.Close()
+
+	client := newHttp3Client(t)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodPost, "https://"+conn.LocalAddr().String(), []byte("hello"), nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+
+	require.Equal(t, "HTTP/3.0 hello", string(harEntry.Response.Content.Data))
+	require.Equal(t, "HTTP/3.0", harEntry.Request.HTTPVersion)
+	require.Equal(t, "HTTP/3.0", harEntry.Response.HTTPVersion)
+
+	spans := tracer.FinishedSpans()
+	require.Equal(t, "HTTP/3.0", spans[len(spans)-1].Tag(restclient.HttpVersionTraceTag))
+}
+
+func TestHttp3Fallback(t *testing.T) {
+
+	// a tcp only server: the QUIC connection cannot be established.
+	srv := httptest.NewUnstartedServer(echoProtoHandler())
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := newHttp3Client(t)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodPost, srv.URL, []byte("hello"), nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0 hello", string(harEntry.Response.Content.Data))
+	require.Equal(t, "HTTP/2.0", harEntry.Response.HTTPVersion)
+
+	// the host is known not to speak HTTP/3: no more QUIC attempts.
+	start := time.Now()
+	request, err = client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0", harEntry.Response.HTTPVersion)
+	require.Less(t, time.Since(start), 200*time.Millisecond)
+}
@@ -0,0 +1,72 @@
+package restclient
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+const DefaultIdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyConfig sets the header carrying the idempotency key, DefaultIdempotencyKeyHeader if empty. The requests with a non idempotent method
+// (POST, PATCH, ...) are retried only if they carry the key; with AutoGenerate a key is generated for those that do not and is kept, the same,
+// for all the attempts of the execution.
+type IdempotencyConfig struct {
+	Header       string `mapstructure:"header,omitempty" json:"header,omitempty" yaml:"header,omitempty"`
+	AutoGenerate bool   `mapstructure:"auto-generate,omitempty" json:"auto-generate,omitempty" yaml:"auto-generate,omitempty"`
+}
+
+// isIdempotentMethod tells whether the method is idempotent as per RFC 9110 section 9.2.2.
+func isIdempotentMethod(m string) bool {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+func (s *Client) idempotencyKeyHeader() string {
+	if s.cfg.Idempotency != nil && s.cfg.Idempotency.Header != "" {
+		return s.cfg.Idempotency.Header
+	}
+
+	return DefaultIdempotencyKeyHeader
+}
+
+// setIdempotencyKey sets the key of the execution, the one of the execution context or a generated one if configured.
+func (ex *execution) setIdempotencyKey() {
+
+	h := ex.client.idempotencyKeyHeader()
+	if isIdempotentMethod(ex.method) || ex.req.Header.Get(h) != "" {
+		return
+	}
+
+	key := ex.execCtx.IdempotencyKey
+	if key == "" && ex.client.cfg.Idempotency != nil && ex.client.cfg.Idempotency.AutoGenerate {
+		key = uuid.NewString()
+	}
+
+	if key == "" {
+		return
+	}
+
+	ex.req.SetHeader(h, key)
+	ex.idempotencyKey = key
+}
+
+// harIdempotencyKey adds the key set by the execution to the request of the entry. The request definition is left untouched: the entry gets a copy.
+func (ex *execution) harIdempotencyKey() {
+	if ex.idempotencyKey == "" {
+		return
+	}
+
+	harReq := *ex.entry.Request
+	harReq.Headers = append(append(har.NameValuePairs{}, harReq.Headers...), har.NameValuePair{Name: ex.client.idempotencyKeyHeader(), Value: ex.idempotencyKey})
+	ex.entry.Request = &harReq
+}
+
+// retryable tells whether the request can be sent again: a non idempotent one only with an idempotency key.
+func (ex *execution) retryable() bool {
+	return isIdempotentMethod(ex.method) || ex.req.Header.Get(ex.client.idempotencyKeyHeader()) != ""
+}
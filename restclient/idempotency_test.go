@@ -0,0 +1,80 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestIdempotencyAwareRetries(t *testing.T) {
+
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get(restclient.DefaultIdempotencyKeyHeader))
+		if len(keys)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	attempts := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		k := keys
+		keys = nil
+		return k
+	}
+
+	cfg := restclient.Config{RetryCount: 2, RetryOnHttpError: []int{http.StatusServiceUnavailable}}
+	client := restclient.NewClient(&cfg)
+	defer client.Close()
+
+	// an idempotent method is retried.
+	request, err := client.NewRequest(http.MethodPut, srv.URL, []byte(`{}`), nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, harEntry.Response.Status)
+	require.Equal(t, []string{"", ""}, attempts())
+
+	// a POST is not, unless it carries an idempotency key.
+	request, err = client.NewRequest(http.MethodPost, srv.URL, []byte(`{}`), nil, nil)
+	require.NoError(t, err)
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, harEntry.Response.Status)
+	require.Equal(t, []string{""}, attempts())
+
+	harEntry, err = client.Execute(request, restclient.ExecutionWithIdempotencyKey("payment-42"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, harEntry.Response.Status)
+	require.Equal(t, []string{"payment-42", "payment-42"}, attempts())
+	require.Equal(t, "payment-42", harEntry.Request.Headers.GetFirst(restclient.DefaultIdempotencyKeyHeader).Value)
+
+	// a generated key is the same for all the attempts of an execution and changes with the execution.
+	cfg.Idempotency = &restclient.IdempotencyConfig{AutoGenerate: true}
+	client = restclient.NewClient(&cfg)
+	defer client.Close()
+
+	var generated []string
+	for i := 0; i < 2; i++ {
+		harEntry, err = client.Execute(request)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, harEntry.Response.Status)
+
+		k := attempts()
+		require.Len(t, k, 2)
+		require.NotEmpty(t, k[0])
+		require.Equal(t, k[0], k[1])
+		require.Equal(t, k[0], harEntry.Request.Headers.GetFirst(restclient.DefaultIdempotencyKeyHeader).Value)
+		generated = append(generated, k[0])
+	}
+	require.NotEqual(t, generated[0], generated[1])
+	require.Empty(t, request.Headers.GetFirst(restclient.DefaultIdempotencyKeyHeader).Value)
+}
@@ -0,0 +1,217 @@
+package restclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OAuth2AuthStyleHeader = "header"
+	OAuth2AuthStyleParams = "params"
+
+	DefaultOAuth2ExpiryDelta = 30 * time.Second
+	DefaultOAuth2Timeout     = 10 * time.Second
+)
+
+var ErrTokenSource = errors.New("unable to get an oauth2 token")
+
+type OAuth2Config struct {
+	TokenUrl     string   `mapstructure:"token-url,omitempty" json:"token-url,omitempty" yaml:"token-url,omitempty"`
+	ClientId     string   `mapstructure:"client-id,omitempty" json:"client-id,omitempty" yaml:"client-id,omitempty"`
+	ClientSecret string   `mapstructure:"client-secret,omitempty" json:"client-secret,omitempty" yaml:"client-secret,omitempty"`
+	Scopes       []string `mapstructure:"scopes,omitempty" json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	// Params are additional parameters of the token request (i.e. audience).
+	Params []Header `mapstructure:"params,omitempty" json:"params,omitempty" yaml:"params,omitempty"`
+	// AuthStyle tells how the client credentials are sent: basic auth header (default) or form params.
+	AuthStyle string `mapstructure:"auth-style,omitempty" json:"auth-style,omitempty" yaml:"auth-style,omitempty"`
+	// ExpiryDelta is how long before the actual expiry a token is considered expired.
+	ExpiryDelta time.Duration `mapstructure:"expiry-delta,omitempty" json:"expiry-delta,omitempty" yaml:"expiry-delta,omitempty"`
+	Timeout     time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// SkipVerify disables the verification of the certificate of the token endpoint. The other tls and proxy settings are the ones of the service.
+	SkipVerify bool `mapstructure:"skv,omitempty" json:"skv,omitempty" yaml:"skv,omitempty"`
+}
+
+type Token struct {
+	AccessToken string
+	TokenType   string
+	Expiry      time.Time
+}
+
+// TokenSource provides the token to be set in the Authorization header of each request. rejected, if not nil, is a token the server replied
+// a 401 to: a new one has to be provided unless it has already been replaced in the meantime.
+type TokenSource interface {
+	Token(ctx context.Context, rejected *Token) (*Token, error)
+}
+
+// ClientCredentialsTokenSource implements the oauth2 client credentials grant. The token is cached until ExpiryDelta before its expiry; concurrent
+// callers wait for a single refresh.
+type ClientCredentialsTokenSource struct {
+	cfg        OAuth2Config
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	token *Token
+}
+
+func NewClientCredentialsTokenSource(cfg *OAuth2Config) (*ClientCredentialsTokenSource, error) {
+	if cfg == nil || cfg.TokenUrl == "" || cfg.ClientId == "" {
+		return nil, fmt.Errorf("%w: token-url and client-id are mandatory", ErrTokenSource)
+	}
+
+	ts := &ClientCredentialsTokenSource{cfg: *cfg}
+	if ts.cfg.ExpiryDelta == 0 {
+		ts.cfg.ExpiryDelta = DefaultOAuth2ExpiryDelta
+	}
+
+	if ts.cfg.Timeout == 0 {
+		ts.cfg.Timeout = DefaultOAuth2Timeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ts.cfg.SkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	ts.httpClient = &http.Client{Timeout: ts.cfg.Timeout, Transport: transport}
+
+	return ts, nil
+}
+
+// newClientCredentialsTokenSource builds the token source on the round tripper of the service, the default one if nil.
+func newClientCredentialsTokenSource(cfg *OAuth2Config, rt http.RoundTripper) (*ClientCredentialsTokenSource, error) {
+	ts, err := NewClientCredentialsTokenSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if rt != nil {
+		ts.httpClient.Transport = rt
+	}
+
+	return ts, nil
+}
+
+// tokenTransport is the round tripper of the token requests: the one of the service, so that its tls and proxy settings apply to the token
+// endpoint as well. The skv of the oauth2 section gets a transport of its own, built out of the same settings.
+func tokenTransport(cfg *Config, ts *transportSetup) http.RoundTripper {
+
+	const semLogContext = "http-client::token-transport"
+
+	if cfg.OAuth2.SkipVerify && !cfg.SkipVerify && cfg.HttpTransport == nil {
+		c := *cfg
+		c.SkipVerify = true
+		sts, err := newTransport(&c)
+		if err == nil {
+			return sts.roundTripper()
+		}
+		log.Error().Err(err).Msg(semLogContext + " invalid transport config... using the one of the service")
+	}
+
+	if ts != nil {
+		return ts.roundTripper()
+	}
+
+	return cfg.HttpTransport
+}
+
+func (ts *ClientCredentialsTokenSource) Token(ctx context.Context, rejected *Token) (*Token, error) {
+
+	const semLogContext = "http-client::client-credentials-token"
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// Concurrent requests rejected with the same token trigger a single refresh: the ones coming later find the new token in place.
+	forceRefresh := rejected != nil && rejected == ts.token
+	if !forceRefresh && ts.token != nil && (ts.token.Expiry.IsZero() || time.Now().Add(ts.cfg.ExpiryDelta).Before(ts.token.Expiry)) {
+		return ts.token, nil
+	}
+
+	tok, err := ts.fetch(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("token-url", ts.cfg.TokenUrl).Msg(semLogContext)
+		return nil, err
+	}
+
+	log.Trace().Str("token-url", ts.cfg.TokenUrl).Time("expiry", tok.Expiry).Bool("forced", forceRefresh).Msg(semLogContext + " token refreshed")
+	ts.token = tok
+	return tok, nil
+}
+
+func (ts *ClientCredentialsTokenSource) fetch(ctx context.Context) (*Token, error) {
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(ts.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(ts.cfg.Scopes, " "))
+	}
+
+	for _, p := range ts.cfg.Params {
+		form.Add(p.Name, p.Value)
+	}
+
+	if ts.cfg.AuthStyle == OAuth2AuthStyleParams {
+		form.Set("client_id", ts.cfg.ClientId)
+		form.Set("client_secret", ts.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.cfg.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenSource, err)
+	}
+
+	req.Header.Set("Content-Type", ContentTypeFormUrlEncoded)
+	req.Header.Set("Accept", "application/json")
+	if ts.cfg.AuthStyle != OAuth2AuthStyleParams {
+		req.SetBasicAuth(url.QueryEscape(ts.cfg.ClientId), url.QueryEscape(ts.cfg.ClientSecret))
+	}
+
+	resp, err := ts.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenSource, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenSource, err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%w: token endpoint replied %d: %s", ErrTokenSource, resp.StatusCode, string(b))
+	}
+
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if err = json.Unmarshal(b, &tr); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenSource, err)
+	}
+
+	if tr.AccessToken == "" {
+		return nil, fmt.Errorf("%w: empty access_token in response", ErrTokenSource)
+	}
+
+	tok := &Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType}
+	if tok.TokenType == "" || strings.EqualFold(tok.TokenType, "bearer") {
+		tok.TokenType = "Bearer"
+	}
+
+	if tr.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+
+	return tok, nil
+}
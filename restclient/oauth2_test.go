@@ -0,0 +1,136 @@
+package restclient_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+
+	var issued int32
+	var revoked atomic.Value
+	revoked.Store("")
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "my-client" || secret != "my-secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	defer idp.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" || auth == revoked.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(auth))
+	}))
+	defer api.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{
+		OAuth2: &restclient.OAuth2Config{TokenUrl: idp.URL, ClientId: "my-client", ClientSecret: "my-secret"},
+	})
+	require.NoError(t, err)
+
+	// the outcomes are checked by the test goroutine.
+	auths := make([]string, 5)
+	errs := make([]error, 5)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := lks.NewClient()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, api.URL, nil, nil, nil)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			harEntry, err := client.Execute(request)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			auths[i] = string(harEntry.Response.Content.Data)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range auths {
+		require.NoError(t, errs[i])
+		require.Equal(t, "Bearer tok-1", auths[i])
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&issued))
+
+	// the token gets revoked: a 401 forces a refresh and the request is retried once.
+	revoked.Store("Bearer tok-1")
+	client, err := lks.NewClient()
+	require.NoError(t, err)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, api.URL, nil, nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer tok-2", string(harEntry.Response.Content.Data))
+	require.Equal(t, int32(2), atomic.LoadInt32(&issued))
+}
+
+func TestOAuth2ServiceTransport(t *testing.T) {
+
+	// the token endpoint presents a certificate issued by the ca of the service.
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	writeCertPEM(t, filepath.Join(dir, "ca.pem"), ca.cert)
+	cert, key := ca.issue(t, "idp", "127.0.0.1")
+
+	idp := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"tok","token_type":"bearer","expires_in":3600}`))
+	}))
+	idp.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+	idp.StartTLS()
+	defer idp.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer api.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{
+		TLS:    &restclient.TLSConfig{CaFiles: []string{filepath.Join(dir, "ca.pem")}},
+		OAuth2: &restclient.OAuth2Config{TokenUrl: idp.URL, ClientId: "my-client", ClientSecret: "my-secret"},
+	})
+	require.NoError(t, err)
+
+	client, err := lks.NewClient()
+	require.NoError(t, err)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, api.URL, nil, nil, nil)
+	require.NoError(t, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "Bearer tok", string(harEntry.Response.Content.Data))
+}
@@ -0,0 +1,77 @@
+package restclient_test
+
+import (
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProtocols(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	h2c := httptest.NewUnstartedServer(handler)
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetHTTP1(true)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	testCases := []struct {
+		name      string
+		url       string
+		protocols []string
+		expected  string
+	}{
+		{name: "alpn-h2", url: h2.URL, expected: "HTTP/2.0"},
+		{name: "force-http1", url: h2.URL, protocols: []string{restclient.ProtocolHTTP1}, expected: "HTTP/1.1"},
+		{name: "cleartext-default", url: h2c.URL, expected: "HTTP/1.1"},
+		{name: "h2c", url: h2c.URL, protocols: []string{restclient.ProtocolHTTP2, restclient.ProtocolH2C}, expected: "HTTP/2.0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lks, err := restclient.NewInstanceWithConfig(&restclient.Config{SkipVerify: true, Transport: &restclient.TransportConfig{Protocols: tc.protocols}})
+			require.NoError(t, err)
+
+			client, err := lks.NewClient()
+			require.NoError(t, err)
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, tc.url, nil, nil, nil)
+			require.NoError(t, err)
+			harEntry, err := client.Execute(request)
+			require.NoError(t, err)
+
+			require.Equal(t, tc.expected, string(harEntry.Response.Content.Data))
+			require.Equal(t, tc.expected, harEntry.Request.HTTPVersion)
+			require.Equal(t, tc.expected, harEntry.Response.HTTPVersion)
+		})
+	}
+
+	// without a response the entry keeps the default version.
+	client := restclient.NewClient(&restclient.Config{})
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, "http://127.0.0.1:1", nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, restclient.DefaultHTTPVersion, request.HTTPVersion)
+
+	harEntry, err := client.Execute(request)
+	require.Error(t, err)
+	require.Equal(t, restclient.DefaultHTTPVersion, harEntry.Request.HTTPVersion)
+	require.Equal(t, restclient.DefaultHTTPVersion, harEntry.Response.HTTPVersion)
+
+	_, err = restclient.NewInstanceWithConfig(&restclient.Config{Transport: &restclient.TransportConfig{Protocols: []string{"spdy"}}})
+	require.True(t, errors.Is(err, restclient.ErrTransportConfig), err)
+}
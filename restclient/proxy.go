@@ -0,0 +1,68 @@
+package restclient
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/http/httpproxy"
+	"net/url"
+	"strings"
+)
+
+var ErrProxyConfig = errors.New("invalid proxy config")
+
+// ProxyConfig routes the requests through a proxy. Url schemes are http, https (TLS to the proxy) and socks5. Https targets go through an
+// http(s) proxy with a CONNECT; the credentials, if any, are sent as Proxy-Authorization basic credentials or as socks5 username/password.
+// NoProxy entries are host names (a leading dot matches the subdomains only), IP addresses or CIDRs, optionally with a port, or * to disable the proxy.
+// Requests to localhost and loopback addresses never go through the proxy.
+type ProxyConfig struct {
+	Url      string   `mapstructure:"url,omitempty" json:"url,omitempty" yaml:"url,omitempty"`
+	Username string   `mapstructure:"username,omitempty" json:"username,omitempty" yaml:"username,omitempty"`
+	Password string   `mapstructure:"password,omitempty" json:"password,omitempty" yaml:"password,omitempty"`
+	NoProxy  []string `mapstructure:"no-proxy,omitempty" json:"no-proxy,omitempty" yaml:"no-proxy,omitempty"`
+}
+
+type proxyFunc func(reqURL *url.URL) (*url.URL, error)
+
+func newProxyFunc(cfg *ProxyConfig) (proxyFunc, error) {
+
+	u, err := url.Parse(cfg.Url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProxyConfig, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme in %s", ErrProxyConfig, u.Redacted())
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: missing host in %s", ErrProxyConfig, u.Redacted())
+	}
+
+	if cfg.Username != "" {
+		u.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	pc := httpproxy.Config{HTTPProxy: u.String(), HTTPSProxy: u.String(), NoProxy: strings.Join(cfg.NoProxy, ",")}
+	return pc.ProxyFunc(), nil
+}
+
+// proxyFor returns the proxy, credentials masked, the request to u goes through. An empty string means a direct connection.
+func (s *Client) proxyFor(u string) string {
+	if s.proxyFunc == nil {
+		return ""
+	}
+
+	reqURL, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	p, err := s.proxyFunc(reqURL)
+	if err != nil || p == nil {
+		return ""
+	}
+
+	return p.Redacted()
+}
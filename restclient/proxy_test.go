@@ -0,0 +1,99 @@
+package restclient_test
+
+import (
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tunneled " + r.Host))
+	}))
+	defer target.Close()
+
+	var unauthorized int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwd2Q=" {
+			atomic.AddInt32(&unauthorized, 1)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		if r.Method != http.MethodConnect {
+			_, _ = w.Write([]byte("proxied " + r.URL.String()))
+			return
+		}
+
+		// every tunnel ends up to the tls target, whatever the requested host.
+		upstream, err := net.Dial("tcp", target.Listener.Addr().String())
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		go func() {
+			_, _ = io.Copy(upstream, brw)
+			_ = upstream.Close()
+		}()
+		_, _ = io.Copy(conn, upstream)
+		_ = conn.Close()
+	}))
+	defer proxy.Close()
+
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("direct"))
+	}))
+	defer direct.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{
+		SkipVerify: true,
+		Proxy:      &restclient.ProxyConfig{Url: proxy.URL, Username: "user", Password: "pwd"},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		url      string
+		expected string
+		proxied  bool
+	}{
+		{url: "http://api.internal/resource?id=1", expected: "proxied http://api.internal/resource?id=1", proxied: true},
+		{url: "https://secure.internal/resource", expected: "tunneled secure.internal", proxied: true},
+		{url: direct.URL, expected: "direct"},
+	}
+
+	for _, tc := range testCases {
+		client, err := lks.NewClient()
+		require.NoError(t, err)
+
+		request, err := client.NewRequest(http.MethodGet, tc.url, nil, nil, nil)
+		require.NoError(t, err)
+		harEntry, err := client.Execute(request, restclient.ExecutionWithRequestId("req-1"))
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, string(harEntry.Response.Content.Data))
+
+		if tc.proxied {
+			require.True(t, strings.HasPrefix(harEntry.Comment, "req-1; proxy: http://user:xxxxx@"), harEntry.Comment)
+			require.NotContains(t, harEntry.Comment, "pwd")
+		} else {
+			require.Equal(t, "req-1", harEntry.Comment)
+		}
+		client.Close()
+	}
+
+	require.Equal(t, int32(0), atomic.LoadInt32(&unauthorized))
+
+	_, err = restclient.NewInstanceWithConfig(&restclient.Config{Proxy: &restclient.ProxyConfig{Url: "ftp://proxy.internal:21"}})
+	require.True(t, errors.Is(err, restclient.ErrProxyConfig), err)
+}
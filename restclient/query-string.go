@@ -0,0 +1,108 @@
+package restclient
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"mime"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const (
+	ContentTypeFormUrlEncoded = "application/x-www-form-urlencoded"
+)
+
+func isFormUrlEncoded(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && mt == ContentTypeFormUrlEncoded
+}
+
+// parseQueryString splits a raw query in name value pairs. Differently from url.ParseQuery the order of the parameters is preserved
+// and the same name can occur more than once. A malformed escape is kept as is: the raw query is what gets sent anyway.
+func parseQueryString(rawQuery string) har.NameValuePairs {
+	qs := har.NameValuePairs{}
+	for _, kv := range strings.Split(rawQuery, "&") {
+		if kv == "" {
+			continue
+		}
+
+		n, v, _ := strings.Cut(kv, "=")
+		qs = append(qs, har.NameValuePair{Name: unescapeQuery(n), Value: unescapeQuery(v)})
+	}
+
+	return qs
+}
+
+func unescapeQuery(s string) string {
+	if u, err := url.QueryUnescape(s); err == nil {
+		return u
+	}
+
+	return s
+}
+
+// encodeQueryString is the inverse of parseQueryString.
+func encodeQueryString(qs har.NameValuePairs) string {
+	var sb strings.Builder
+	for i, nv := range qs {
+		if i > 0 {
+			sb.WriteString("&")
+		}
+		sb.WriteString(url.QueryEscape(nv.Name))
+		sb.WriteString("=")
+		sb.WriteString(url.QueryEscape(nv.Value))
+	}
+
+	return sb.String()
+}
+
+// splitUrl separates the raw query part from the rest of the url. The fragment, not sent on the wire, is dropped.
+func splitUrl(u string) (string, string, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", "", err
+	}
+
+	rawQuery := pu.RawQuery
+	pu.RawQuery = ""
+	pu.ForceQuery = false
+	pu.Fragment = ""
+	pu.RawFragment = ""
+	return pu.String(), rawQuery, nil
+}
+
+// joinUrl appends the params, encoded, to the raw query left as is.
+func joinUrl(base string, rawQuery string, qs har.NameValuePairs) string {
+	if len(qs) > 0 {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += encodeQueryString(qs)
+	}
+
+	if rawQuery == "" {
+		return base
+	}
+
+	return base + "?" + rawQuery
+}
+
+// requestUrl builds the url to be sent. The query of the url is sent as is, followed by the params of the QueryString it does not hold yet
+// (NewRequest keeps both in sync, the params of the url coming first). A QueryString edited otherwise replaces the query of the url.
+func requestUrl(reqDef *har.Request) (string, error) {
+	if len(reqDef.QueryString) == 0 {
+		return reqDef.URL, nil
+	}
+
+	base, rawQuery, err := splitUrl(reqDef.URL)
+	if err != nil {
+		return "", err
+	}
+
+	inUrl := parseQueryString(rawQuery)
+	if len(reqDef.QueryString) < len(inUrl) || !slices.Equal(reqDef.QueryString[:len(inUrl)], inUrl) {
+		return joinUrl(base, "", reqDef.QueryString), nil
+	}
+
+	return joinUrl(base, rawQuery, reqDef.QueryString[len(inUrl):]), nil
+}
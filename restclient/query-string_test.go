@@ -0,0 +1,73 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryString(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.URL.RawQuery + "|" + string(b)))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(nil)
+	defer client.Close()
+
+	params := har.NameValuePairs{{Name: "tag", Value: "b c"}, {Name: "q", Value: "x&y"}}
+	request, err := client.NewRequest(http.MethodGet, srv.URL+"/search?tag=a#fragment", nil, nil, params)
+	require.NoError(t, err)
+	require.Equal(t, har.NameValuePairs{{Name: "tag", Value: "a"}, {Name: "tag", Value: "b c"}, {Name: "q", Value: "x&y"}}, request.QueryString)
+	require.Equal(t, srv.URL+"/search?tag=a&tag=b+c&q=x%26y", request.URL)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "tag=a&tag=b+c&q=x%26y|", string(harEntry.Response.Content.Data))
+
+	// form-urlencoded: params are the body, not the query.
+	headers := har.NameValuePairs{{Name: "Content-Type", Value: restclient.ContentTypeFormUrlEncoded}}
+	request, err = client.NewRequest(http.MethodPost, srv.URL+"/form?v=1", nil, headers, params)
+	require.NoError(t, err)
+	require.Len(t, request.PostData.Params, 2)
+
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "v=1|tag=b+c&q=x%26y", string(harEntry.Response.Content.Data))
+}
+
+func TestQueryStringRawQuery(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(nil)
+	defer client.Close()
+
+	// the query of the url is sent as is, only the params are encoded.
+	request, err := client.NewRequest(http.MethodGet, srv.URL+"/search?fields=id,name", nil, nil, har.NameValuePairs{{Name: "sort", Value: "a,b"}})
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/search?fields=id,name&sort=a%2Cb", request.URL)
+	require.Equal(t, har.NameValuePairs{{Name: "fields", Value: "id,name"}, {Name: "sort", Value: "a,b"}}, request.QueryString)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "fields=id,name&sort=a%2Cb", string(harEntry.Response.Content.Data))
+
+	// a malformed escape is accepted and kept.
+	request, err = client.NewRequest(http.MethodGet, srv.URL+"/search?q=100%", nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, har.NameValuePairs{{Name: "q", Value: "100%"}}, request.QueryString)
+
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "q=100%", string(harEntry.Response.Content.Data))
+}
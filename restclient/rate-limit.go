@@ -0,0 +1,113 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+	"math"
+	"time"
+)
+
+const (
+	RateLimitModeWait = "wait"
+	RateLimitModeFail = "fail"
+)
+
+var (
+	ErrRateLimitConfig = errors.New("invalid rate limit config")
+	ErrRateLimited     = errors.New("client rate limit exceeded")
+)
+
+// RateLimitConfig throttles the requests, retries included, with a token bucket of Burst tokens refilled at RequestsPerSecond. Burst defaults to
+// the rate rounded up. In wait mode, the default, a request waits for its token up to MaxWait (no limit if zero, the context deadline aside) and
+// fails with ErrRateLimited if it would take longer; in fail mode it fails right away. The wait is reported as blocked time in the har entry.
+type RateLimitConfig struct {
+	RequestsPerSecond float64       `mapstructure:"requests-per-second,omitempty" json:"requests-per-second,omitempty" yaml:"requests-per-second,omitempty"`
+	Burst             int           `mapstructure:"burst,omitempty" json:"burst,omitempty" yaml:"burst,omitempty"`
+	Mode              string        `mapstructure:"mode,omitempty" json:"mode,omitempty" yaml:"mode,omitempty"`
+	MaxWait           time.Duration `mapstructure:"max-wait,omitempty" json:"max-wait,omitempty" yaml:"max-wait,omitempty"`
+}
+
+type rateLimiter struct {
+	limiter *rate.Limiter
+	mode    string
+	maxWait time.Duration
+}
+
+func newRateLimiter(cfg *RateLimitConfig) (*rateLimiter, error) {
+
+	if cfg.RequestsPerSecond <= 0 {
+		return nil, fmt.Errorf("%w: requests per second must be positive", ErrRateLimitConfig)
+	}
+
+	mode := cfg.Mode
+	switch mode {
+	case "":
+		mode = RateLimitModeWait
+	case RateLimitModeWait, RateLimitModeFail:
+	default:
+		return nil, fmt.Errorf("%w: unsupported mode %s", ErrRateLimitConfig, mode)
+	}
+
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(cfg.RequestsPerSecond))
+	}
+
+	return &rateLimiter{limiter: rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), burst), mode: mode, maxWait: cfg.MaxWait}, nil
+}
+
+// wait takes a token, waiting for it if the mode allows, and returns the time spent waiting.
+func (rl *rateLimiter) wait(ctx context.Context) (time.Duration, error) {
+
+	r := rl.limiter.Reserve()
+	d := r.Delay()
+	if d == 0 {
+		return 0, nil
+	}
+
+	deadline, ok := ctx.Deadline()
+	if rl.mode == RateLimitModeFail || (rl.maxWait > 0 && d > rl.maxWait) || (ok && time.Until(deadline) < d) {
+		r.Cancel()
+		return 0, fmt.Errorf("%w: next token in %v", ErrRateLimited, d)
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return d, nil
+	case <-ctx.Done():
+		r.Cancel()
+		return 0, ctx.Err()
+	}
+}
+
+// executionTimingsKey is the context key of the timings of the execution the attempts of a request belong to.
+type executionTimingsKey struct{}
+
+// waitRateLimit is the resty request middleware enforcing the rate limit on every attempt.
+func (s *Client) waitRateLimit(_ *resty.Client, req *resty.Request) error {
+
+	const semLogContext = "http-client::rate-limit"
+
+	ctx := req.Context()
+	d, err := s.rateLimiter.wait(ctx)
+	if err != nil {
+		log.Warn().Err(err).Str("url", req.URL).Msg(semLogContext + " request not sent")
+		return err
+	}
+
+	if d > 0 {
+		log.Debug().Dur("wait", d).Str("url", req.URL).Msg(semLogContext + " request delayed")
+		if t, ok := ctx.Value(executionTimingsKey{}).(*harTimingsTrace); ok {
+			t.addBlocked(d)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package restclient_test
+
+import (
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer srv.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{RateLimit: &restclient.RateLimitConfig{RequestsPerSecond: 10, Burst: 1}})
+	require.NoError(t, err)
+
+	// the clients of the linked service share the bucket: after the first request each one waits for its token.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		client, err := lks.NewClient()
+		require.NoError(t, err)
+
+		request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+		require.NoError(t, err)
+		harEntry, err := client.Execute(request)
+		require.NoError(t, err)
+		client.Close()
+
+		if i > 0 {
+			require.Greater(t, harEntry.Timings.Blocked, 50.0)
+		}
+	}
+	require.GreaterOrEqual(t, time.Since(start), 180*time.Millisecond)
+
+	testCases := []struct {
+		name string
+		cfg  *restclient.RateLimitConfig
+	}{
+		{name: "fail", cfg: &restclient.RateLimitConfig{RequestsPerSecond: 1, Mode: restclient.RateLimitModeFail}},
+		{name: "max-wait", cfg: &restclient.RateLimitConfig{RequestsPerSecond: 1, MaxWait: 100 * time.Millisecond}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt64(&hits, 0)
+			client := restclient.NewClient(&restclient.Config{RetryCount: 2, RateLimit: tc.cfg})
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+			require.NoError(t, err)
+			_, err = client.Execute(request)
+			require.NoError(t, err)
+
+			start := time.Now()
+			harEntry, err := client.Execute(request)
+			require.True(t, errors.Is(err, restclient.ErrRateLimited), err)
+			require.Equal(t, http.StatusTooManyRequests, harEntry.Response.Status)
+			require.Less(t, time.Since(start), 50*time.Millisecond)
+			require.Equal(t, int64(1), atomic.LoadInt64(&hits))
+		})
+	}
+
+	_, err = restclient.NewInstanceWithConfig(&restclient.Config{RateLimit: &restclient.RateLimitConfig{}})
+	require.True(t, errors.Is(err, restclient.ErrRateLimitConfig), err)
+}
@@ -0,0 +1,389 @@
+package restclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/rs/zerolog/log"
+	"mime"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const DefaultRedactionMask = "***"
+
+// RedactionConfig lists what has to be masked in the har entries handed to the har tracer and in the values set as span tags.
+// The entry returned to the caller is left untouched.
+type RedactionConfig struct {
+	Mask string `mapstructure:"mask,omitempty" json:"mask,omitempty" yaml:"mask,omitempty"`
+	// Headers are header names, case insensitive, DefaultRedactedHeaders if not set. The cookies are masked as well if the 'cookie' or 'set-cookie'
+	// headers are listed.
+	Headers []string `mapstructure:"headers,omitempty" json:"headers,omitempty" yaml:"headers,omitempty"`
+	// HeaderPatterns are regexes matched against the lowercase header names.
+	HeaderPatterns []string `mapstructure:"header-patterns,omitempty" json:"header-patterns,omitempty" yaml:"header-patterns,omitempty"`
+	// QueryParams are the names of the query parameters to be masked in the url and in the query string.
+	QueryParams []string `mapstructure:"query-params,omitempty" json:"query-params,omitempty" yaml:"query-params,omitempty"`
+	// RequestBodyPaths and ResponseBodyPaths are json paths of the form $.a.b[*].c ($. is optional, [n] selects an element, * any field or element)
+	// applied to json bodies. A body that cannot be parsed as json, a truncated one included, is masked as a whole.
+	RequestBodyPaths  []string `mapstructure:"req-body-paths,omitempty" json:"req-body-paths,omitempty" yaml:"req-body-paths,omitempty"`
+	ResponseBodyPaths []string `mapstructure:"resp-body-paths,omitempty" json:"resp-body-paths,omitempty" yaml:"resp-body-paths,omitempty"`
+}
+
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "Cookie", "Set-Cookie"}
+
+type redactor struct {
+	mask           string
+	headers        map[string]struct{}
+	headerPatterns []*regexp.Regexp
+	queryParams    map[string]struct{}
+	reqBodyPaths   [][]jsonPathSegment
+	respBodyPaths  [][]jsonPathSegment
+}
+
+func newRedactor(cfg *RedactionConfig) *redactor {
+
+	const semLogContext = "http-client::new-redactor"
+
+	if cfg == nil {
+		return nil
+	}
+
+	r := &redactor{mask: cfg.Mask, headers: make(map[string]struct{}), queryParams: make(map[string]struct{})}
+	if r.mask == "" {
+		r.mask = DefaultRedactionMask
+	}
+
+	hs := cfg.Headers
+	if hs == nil {
+		hs = DefaultRedactedHeaders
+	}
+
+	for _, h := range hs {
+		r.headers[strings.ToLower(h)] = struct{}{}
+	}
+
+	for _, p := range cfg.HeaderPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Error().Err(err).Str("pattern", p).Msg(semLogContext + " invalid header pattern... skipped")
+			continue
+		}
+		r.headerPatterns = append(r.headerPatterns, re)
+	}
+
+	for _, q := range cfg.QueryParams {
+		r.queryParams[q] = struct{}{}
+	}
+
+	r.reqBodyPaths = compileJsonPaths(cfg.RequestBodyPaths)
+	r.respBodyPaths = compileJsonPaths(cfg.ResponseBodyPaths)
+	return r
+}
+
+// addHeaders extends the list of the redacted headers: used by the features that set secrets in headers on their own.
+func (r *redactor) addHeaders(names ...string) {
+	for _, n := range names {
+		r.headers[strings.ToLower(n)] = struct{}{}
+	}
+}
+
+// redactSecretHeaders masks the headers carrying the credentials set by the client itself, even if the config has no redaction policy.
+func (s *Client) redactSecretHeaders(names ...string) {
+	if s.redactor == nil {
+		s.redactor = newRedactor(&RedactionConfig{})
+	}
+	s.redactor.addHeaders(names...)
+}
+
+func (r *redactor) isRedactedHeader(n string) bool {
+	n = strings.ToLower(n)
+	if _, ok := r.headers[n]; ok {
+		return true
+	}
+
+	for _, re := range r.headerPatterns {
+		if re.MatchString(n) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *redactor) redactHeaders(hs har.NameValuePairs) har.NameValuePairs {
+	if hs == nil {
+		return nil
+	}
+
+	out := make(har.NameValuePairs, len(hs))
+	for i, h := range hs {
+		out[i] = h
+		if r.isRedactedHeader(h.Name) {
+			out[i].Value = r.mask
+		}
+	}
+
+	return out
+}
+
+func (r *redactor) redactCookies(cookies []har.Cookie, headerName string) []har.Cookie {
+	if cookies == nil || !r.isRedactedHeader(headerName) {
+		return cookies
+	}
+
+	out := make([]har.Cookie, len(cookies))
+	for i, c := range cookies {
+		out[i] = c
+		out[i].Value = r.mask
+	}
+
+	return out
+}
+
+func (r *redactor) redactQueryString(qs har.NameValuePairs) har.NameValuePairs {
+	if len(r.queryParams) == 0 || qs == nil {
+		return qs
+	}
+
+	out := make(har.NameValuePairs, len(qs))
+	for i, q := range qs {
+		out[i] = q
+		if _, ok := r.queryParams[q.Name]; ok {
+			out[i].Value = r.mask
+		}
+	}
+
+	return out
+}
+
+func (r *redactor) redactParams(params []har.Param) []har.Param {
+	out := make([]har.Param, len(params))
+	for i, p := range params {
+		out[i] = p
+		if p.Value != "" {
+			out[i].Value = r.mask
+		}
+	}
+
+	return out
+}
+
+// redactUrl masks the configured query parameters of u.
+func (r *redactor) redactUrl(u string) string {
+	if r == nil || len(r.queryParams) == 0 {
+		return u
+	}
+
+	base, rawQuery, err := splitUrl(u)
+	if err != nil || rawQuery == "" {
+		return u
+	}
+
+	// the parameters not masked are left as they are.
+	kvs := strings.Split(rawQuery, "&")
+	for i, kv := range kvs {
+		n, _, _ := strings.Cut(kv, "=")
+		if _, ok := r.queryParams[unescapeQuery(n)]; ok {
+			kvs[i] = n + "=" + url.QueryEscape(r.mask)
+		}
+	}
+
+	return base + "?" + strings.Join(kvs, "&")
+}
+
+func (r *redactor) redactBody(data []byte, mimeType string, paths [][]jsonPathSegment) []byte {
+
+	const semLogContext = "http-client::redact-body"
+
+	if len(paths) == 0 || len(data) == 0 {
+		return data
+	}
+
+	// a body the paths cannot be applied to, i.e. a truncated preview, could carry the secrets they mask: it is masked as a whole.
+	if mt, _, err := mime.ParseMediaType(mimeType); err == nil && mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		log.Trace().Str("mime-type", mt).Msg(semLogContext + " body is not json... masked")
+		return []byte(r.mask)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		log.Trace().Err(err).Msg(semLogContext + " body is not json... masked")
+		return []byte(r.mask)
+	}
+
+	for _, p := range paths {
+		doc = maskJsonPath(doc, p, r.mask)
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext)
+		return []byte(r.mask)
+	}
+
+	return b
+}
+
+// redactEntry returns a copy of the entry with the secrets masked. The parts of the entry not affected by the redaction are shared with the original.
+func (r *redactor) redactEntry(e *har.Entry) *har.Entry {
+	if r == nil || e == nil {
+		return e
+	}
+
+	re := *e
+	if e.Request != nil {
+		req := *e.Request
+		req.URL = r.redactUrl(req.URL)
+		req.Headers = r.redactHeaders(req.Headers)
+		req.Cookies = r.redactCookies(req.Cookies, "cookie")
+		req.QueryString = r.redactQueryString(req.QueryString)
+		if req.PostData != nil {
+			pd := *req.PostData
+			pd.Data = r.redactBody(pd.Data, pd.MimeType, r.reqBodyPaths)
+			if len(pd.Data) > 0 {
+				// the text, if already set by a previous marshalling, would be the one of the original.
+				pd.Text = ""
+			}
+			// the params of a form are not json: the paths cannot be applied to their values.
+			if len(r.reqBodyPaths) > 0 && len(pd.Params) > 0 {
+				pd.Params = r.redactParams(pd.Params)
+			}
+			req.PostData = &pd
+		}
+		re.Request = &req
+	}
+
+	if e.Response != nil {
+		resp := *e.Response
+		resp.Headers = r.redactHeaders(resp.Headers)
+		resp.Cookies = r.redactCookies(resp.Cookies, "set-cookie")
+		if resp.Content != nil {
+			c := *resp.Content
+			c.Data = r.redactBody(c.Data, c.MimeType, r.respBodyPaths)
+			if len(c.Data) > 0 {
+				c.Text = ""
+			}
+			resp.Content = &c
+		}
+		re.Response = &resp
+	}
+
+	return &re
+}
+
+// RedactEntry returns a copy of the entry masked according to the redaction policy of the client, useful when entries are logged by the caller.
+// Without a policy the entry itself is returned.
+func (s *Client) RedactEntry(e *har.Entry) *har.Entry {
+	return s.redactor.redactEntry(e)
+}
+
+type jsonPathSegment struct {
+	field string
+	index int
+	// wildcard matches any field of an object or any element of an array.
+	wildcard bool
+	isIndex  bool
+}
+
+func compileJsonPaths(paths []string) [][]jsonPathSegment {
+
+	const semLogContext = "http-client::compile-json-paths"
+
+	var compiled [][]jsonPathSegment
+	for _, p := range paths {
+		segs, err := compileJsonPath(p)
+		if err != nil {
+			log.Error().Err(err).Str("path", p).Msg(semLogContext + " invalid json path... skipped")
+			continue
+		}
+		compiled = append(compiled, segs)
+	}
+
+	return compiled
+}
+
+func compileJsonPath(p string) ([]jsonPathSegment, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	if p == "" {
+		return nil, fmt.Errorf("empty json path")
+	}
+
+	var segs []jsonPathSegment
+	for _, part := range strings.Split(p, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		switch name {
+		case "":
+			if rest == "" {
+				return nil, fmt.Errorf("empty segment in json path")
+			}
+		case "*":
+			segs = append(segs, jsonPathSegment{wildcard: true})
+		default:
+			segs = append(segs, jsonPathSegment{field: name})
+		}
+
+		for rest != "" {
+			var idx string
+			var ok bool
+			idx, rest, ok = strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("unterminated index in json path")
+			}
+
+			if idx == "*" {
+				segs = append(segs, jsonPathSegment{wildcard: true, isIndex: true})
+			} else {
+				n, err := strconv.Atoi(idx)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %s in json path", idx)
+				}
+				segs = append(segs, jsonPathSegment{index: n, isIndex: true})
+			}
+
+			rest = strings.TrimPrefix(rest, "[")
+		}
+	}
+
+	return segs, nil
+}
+
+func maskJsonPath(node interface{}, path []jsonPathSegment, mask string) interface{} {
+	if len(path) == 0 {
+		return mask
+	}
+
+	seg := path[0]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if seg.isIndex {
+			return node
+		}
+
+		if seg.wildcard {
+			for k, v := range n {
+				n[k] = maskJsonPath(v, path[1:], mask)
+			}
+		} else if v, ok := n[seg.field]; ok {
+			n[seg.field] = maskJsonPath(v, path[1:], mask)
+		}
+
+	case []interface{}:
+		if !seg.isIndex && !seg.wildcard {
+			return node
+		}
+
+		for i := range n {
+			if seg.wildcard || i == seg.index {
+				n[i] = maskJsonPath(n[i], path[1:], mask)
+			}
+		}
+	}
+
+	return node
+}
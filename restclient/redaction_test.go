@@ -0,0 +1,88 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Session-Token", "s3cr3t")
+		_, _ = w.Write([]byte(`{"accounts":[{"iban":"IT60X0542811101000000123456","balance":10}],"owner":"mario"}`))
+	}))
+	defer srv.Close()
+
+	cfg := restclient.Config{
+		Headers: []restclient.Header{{Name: "x-api-key", Value: "pippo"}},
+		Redaction: &restclient.RedactionConfig{
+			Headers:           []string{"X-Api-Key"},
+			HeaderPatterns:    []string{"token$"},
+			QueryParams:       []string{"apikey"},
+			RequestBodyPaths:  []string{"$.password"},
+			ResponseBodyPaths: []string{"$.accounts[*].iban"},
+		},
+	}
+
+	client := restclient.NewClient(&cfg)
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodPost, srv.URL+"?apikey=abc&page=1", []byte(`{"user":"mario","password":"pwd"}`), nil, nil)
+	require.NoError(t, err)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+
+	redacted := client.RedactEntry(harEntry)
+	require.Equal(t, "***", redacted.Request.Headers.GetFirst("x-api-key").Value)
+	require.Equal(t, "***", redacted.Response.Headers.GetFirst("X-Session-Token").Value)
+	require.Equal(t, srv.URL+"?apikey=%2A%2A%2A&page=1", redacted.Request.URL)
+	require.Equal(t, har.NameValuePair{Name: "apikey", Value: "***"}, redacted.Request.QueryString[0])
+	require.JSONEq(t, `{"user":"mario","password":"***"}`, string(redacted.Request.PostData.Data))
+	require.JSONEq(t, `{"accounts":[{"iban":"***","balance":10}],"owner":"mario"}`, string(redacted.Response.Content.Data))
+
+	// the entry returned to the caller is untouched.
+	require.Equal(t, "pippo", harEntry.Request.Headers.GetFirst("x-api-key").Value)
+	require.Contains(t, string(harEntry.Response.Content.Data), "IT60X0542811101000000123456")
+}
+
+func TestRedactionDefaults(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"accounts":[{"iban":"IT60X0542811101000000123456"`))
+	}))
+	defer srv.Close()
+
+	cfg := restclient.Config{
+		Headers: []restclient.Header{{Name: "Authorization", Value: "Bearer abc"}, {Name: "X-Request-Id", Value: "req-1"}},
+		Redaction: &restclient.RedactionConfig{
+			RequestBodyPaths:  []string{"$.password"},
+			ResponseBodyPaths: []string{"$.accounts[*].iban"},
+		},
+	}
+
+	client := restclient.NewClient(&cfg)
+	defer client.Close()
+
+	request, err := client.NewFormRequest(http.MethodPost, srv.URL, []har.Param{{Name: "user", Value: "mario"}, {Name: "password", Value: "pwd"}}, nil)
+	require.NoError(t, err)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+
+	// the default headers are masked, the others are not.
+	redacted := client.RedactEntry(harEntry)
+	require.Equal(t, "***", redacted.Request.Headers.GetFirst("Authorization").Value)
+	require.Equal(t, "req-1", redacted.Request.Headers.GetFirst("X-Request-Id").Value)
+
+	// the bodies the paths cannot be applied to are masked as a whole.
+	require.Equal(t, "***", string(redacted.Request.PostData.Data))
+	require.Equal(t, []har.Param{{Name: "user", Value: "***"}, {Name: "password", Value: "***"}}, redacted.Request.PostData.Params)
+	require.Equal(t, "***", string(redacted.Response.Content.Data))
+}
@@ -0,0 +1,191 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/go-resty/resty/v2"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ContentTypeMultipartFormData = "multipart/form-data"
+	ContentTypeOctetStream       = "application/octet-stream"
+)
+
+// MultipartPart is a part of a multipart/form-data body: a plain field when FileName is empty, a file otherwise.
+type MultipartPart struct {
+	Name        string
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+func MultipartField(name string, value string) MultipartPart {
+	return MultipartPart{Name: name, Data: []byte(value)}
+}
+
+func MultipartFile(name string, fileName string, contentType string, data []byte) MultipartPart {
+	return MultipartPart{Name: name, FileName: fileName, ContentType: contentType, Data: data}
+}
+
+// MultipartFileFromPath reads the file at path. If contentType is empty it is guessed from the file extension.
+func MultipartFileFromPath(name string, path string, contentType string) (MultipartPart, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return MultipartPart{}, err
+	}
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+
+	return MultipartFile(name, filepath.Base(path), contentType, b), nil
+}
+
+// NewFormRequest creates a request with an application/x-www-form-urlencoded body made of the fields.
+func (s *Client) NewFormRequest(method string, url string, fields []har.Param, headers har.NameValuePairs) (*har.Request, error) {
+
+	params := make(har.NameValuePairs, 0, len(fields))
+	for _, f := range fields {
+		if f.FileName != "" {
+			return nil, fmt.Errorf("file %s (field %s) not allowed in a %s body", f.FileName, f.Name, ContentTypeFormUrlEncoded)
+		}
+		params = append(params, har.NameValuePair{Name: f.Name, Value: f.Value})
+	}
+
+	return s.NewRequest(method, url, nil, withContentType(headers, ContentTypeFormUrlEncoded), params)
+}
+
+// NewMultipartRequest creates a request with a multipart/form-data body. Each part is recorded as a har.Param of the post data; the content
+// of binary files is left out of the value and summarized in the comment.
+func (s *Client) NewMultipartRequest(method string, url string, parts []MultipartPart, headers har.NameValuePairs) (*har.Request, error) {
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	params := make([]har.Param, 0, len(parts))
+	for _, p := range parts {
+		h := make(textproto.MIMEHeader)
+		disposition := fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(p.Name))
+		if p.FileName != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, escapeQuotes(p.FileName))
+			if p.ContentType == "" {
+				p.ContentType = ContentTypeOctetStream
+			}
+		}
+		h.Set("Content-Disposition", disposition)
+		if p.ContentType != "" {
+			h.Set("Content-Type", p.ContentType)
+		}
+
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err = pw.Write(p.Data); err != nil {
+			return nil, err
+		}
+
+		param := har.Param{Name: p.Name, FileName: p.FileName, ContentType: p.ContentType}
+		if utf8.Valid(p.Data) {
+			param.Value = string(p.Data)
+		} else {
+			param.Comment = fmt.Sprintf("binary content of %d bytes", len(p.Data))
+		}
+		params = append(params, param)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := s.NewRequest(method, url, body.Bytes(), withContentType(headers, w.FormDataContentType()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.PostData.Params = params
+	return req, nil
+}
+
+// withContentType returns a copy of the headers where the content-type, if any, is replaced by ct.
+func withContentType(headers har.NameValuePairs, ct string) har.NameValuePairs {
+	hs := make(har.NameValuePairs, 0, len(headers)+1)
+	for _, h := range headers {
+		if strings.ToLower(h.Name) != "content-type" {
+			hs = append(hs, h)
+		}
+	}
+
+	return append(hs, har.NameValuePair{Name: "Content-Type", Value: ct})
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// droppedBodyKey is the context key of the body of a HEAD or OPTIONS request. Resty does not send it: the pre-request hook of the client sets it
+// on the raw request.
+type droppedBodyKey struct{}
+
+type droppedBody struct {
+	data        []byte
+	reader      io.Reader
+	contentType string
+}
+
+// withDroppedBody adds to ctx the body of the request, if the method is one resty drops the body of. The reader, if any, takes precedence over
+// the data.
+func withDroppedBody(ctx context.Context, method string, reqDef *har.Request, reader io.Reader) context.Context {
+	if method != http.MethodHead && method != http.MethodOptions {
+		return ctx
+	}
+
+	b := droppedBody{reader: reader}
+	if reqDef.HasBody() {
+		b.data, b.contentType = reqDef.PostData.Data, reqDef.PostData.MimeType
+	}
+
+	if b.reader == nil && len(b.data) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, droppedBodyKey{}, b)
+}
+
+// setDroppedBody is the resty pre-request hook sending the body of the HEAD and OPTIONS requests.
+func setDroppedBody(_ *resty.Client, r *http.Request) error {
+
+	b, ok := r.Context().Value(droppedBodyKey{}).(droppedBody)
+	if !ok || (r.Body != nil && r.Body != http.NoBody) {
+		return nil
+	}
+
+	if b.reader != nil {
+		r.Body, r.ContentLength, r.GetBody = io.NopCloser(b.reader), -1, nil
+	} else {
+		r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(b.data)), int64(len(b.data))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(b.data)), nil
+		}
+	}
+
+	if r.Header.Get("Content-Type") == "" && b.contentType != "" {
+		r.Header.Set("Content-Type", b.contentType)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMultipartRequest(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f, fh, err := r.FormFile("document")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+
+		b, _ := io.ReadAll(f)
+		_, _ = w.Write([]byte(r.FormValue("title") + "|" + fh.Filename + "|" + fh.Header.Get("Content-Type") + "|" + string(b)))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(nil)
+	defer client.Close()
+
+	parts := []restclient.MultipartPart{
+		restclient.MultipartField("title", "report"),
+		restclient.MultipartFile("document", "report.pdf", "application/pdf", []byte("%PDF")),
+		restclient.MultipartFile("thumbnail", "thumb.png", "", []byte{0x89, 0xff, 0x00}),
+	}
+
+	request, err := client.NewMultipartRequest(http.MethodPost, srv.URL, parts, har.NameValuePairs{{Name: "Content-Type", Value: "application/json"}})
+	require.NoError(t, err)
+	require.Contains(t, request.PostData.MimeType, restclient.ContentTypeMultipartFormData)
+	require.Len(t, request.PostData.Params, 3)
+	require.Equal(t, "report.pdf", request.PostData.Params[1].FileName)
+	require.Equal(t, restclient.ContentTypeOctetStream, request.PostData.Params[2].ContentType)
+	require.Empty(t, request.PostData.Params[2].Value)
+
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, "report|report.pdf|application/pdf|%PDF", string(harEntry.Response.Content.Data))
+
+	request, err = client.NewFormRequest(http.MethodPost, srv.URL, []har.Param{{Name: "a", Value: "1 2"}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "a=1+2", string(request.PostData.Data))
+	require.Equal(t, restclient.ContentTypeFormUrlEncoded, request.PostData.MimeType)
+}
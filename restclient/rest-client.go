@@ -2,8 +2,8 @@ package restclient
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
+	"fmt"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-common/util"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
 	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/hartracing"
@@ -11,8 +11,10 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/rs/zerolog/log"
+	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"strconv"
@@ -23,14 +25,110 @@ import (
 
 type LinkedService struct {
 	Cfg *Config
+
+	cookieJar   http.CookieJar
+	tokenSource TokenSource
+	transport   *transportSetup
+	breakers    *circuitBreakers
+	rateLimiter *rateLimiter
+	throttle    *throttle
+	digest      *digestState
 }
 
 func NewInstanceWithConfig(cfg *Config) (*LinkedService, error) {
 	lks := &LinkedService{Cfg: cfg}
+
+	if cfg != nil && cfg.CookieJar != nil && cfg.CookieJar.Enabled && cfg.CookieJar.Shared {
+		jar, err := cookieJarForFile(cfg.CookieJar.FileName)
+		if err != nil {
+			return nil, err
+		}
+		lks.cookieJar = jar
+	}
+
+	if cfg == nil || cfg.HttpTransport == nil {
+		var tcfg Config
+		if cfg != nil {
+			tcfg = *cfg
+		}
+
+		ts, err := newTransport(&tcfg)
+		if err != nil {
+			return nil, err
+		}
+		lks.transport = ts
+	}
+
+	if cfg != nil && cfg.OAuth2 != nil && cfg.TokenSource == nil {
+		ts, err := newClientCredentialsTokenSource(cfg.OAuth2, tokenTransport(cfg, lks.transport))
+		if err != nil {
+			return nil, err
+		}
+		lks.tokenSource = ts
+	}
+
+	if cfg != nil && cfg.CircuitBreaker != nil {
+		cbs, err := newCircuitBreakers(cfg.CircuitBreaker)
+		if err != nil {
+			return nil, err
+		}
+		lks.breakers = cbs
+	}
+
+	if cfg != nil && cfg.RateLimit != nil {
+		rl, err := newRateLimiter(cfg.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		lks.rateLimiter = rl
+	}
+
+	if cfg != nil && cfg.Throttle != nil {
+		lks.throttle = newThrottle(cfg.Throttle)
+	}
+
+	if cfg != nil && cfg.Auth != nil && strings.EqualFold(cfg.Auth.Mode, AuthModeDigest) {
+		lks.digest = &digestState{}
+	}
+
+	if cfg != nil && cfg.Retry != nil {
+		if _, err := newRetryPolicies(cfg); err != nil {
+			return nil, err
+		}
+	}
+
 	return lks, nil
 }
 
 func (lks LinkedService) NewClient(opts ...Option) (*Client, error) {
+	if lks.cookieJar != nil {
+		opts = append([]Option{WithCookieJar(lks.cookieJar)}, opts...)
+	}
+
+	if lks.tokenSource != nil {
+		opts = append([]Option{WithTokenSource(lks.tokenSource)}, opts...)
+	}
+
+	if lks.transport != nil {
+		opts = append([]Option{withSharedTransport(lks.transport)}, opts...)
+	}
+
+	if lks.breakers != nil {
+		opts = append([]Option{withSharedCircuitBreakers(lks.breakers)}, opts...)
+	}
+
+	if lks.rateLimiter != nil {
+		opts = append([]Option{withSharedRateLimiter(lks.rateLimiter)}, opts...)
+	}
+
+	if lks.throttle != nil {
+		opts = append([]Option{withSharedThrottle(lks.throttle)}, opts...)
+	}
+
+	if lks.digest != nil {
+		opts = append([]Option{withSharedDigest(lks.digest)}, opts...)
+	}
+
 	cli := NewClient(lks.Cfg, opts...)
 	return cli, nil
 }
@@ -42,7 +140,18 @@ type Client struct {
 	span       opentracing.Span
 	spanOwned  bool
 
-	harSpan hartracing.Span
+	harSpan     hartracing.Span
+	redactor    *redactor
+	tokenSource TokenSource
+	proxyFunc   proxyFunc
+	breakers    *circuitBreakers
+	rateLimiter *rateLimiter
+	throttle    *throttle
+
+	retryPolicies *retryPolicies
+
+	// ownedTransport is the transport created by the client itself, its idle connections are closed with the client.
+	ownedTransport *transportSetup
 }
 
 func NewClient(cfg *Config, opts ...Option) *Client {
@@ -61,9 +170,11 @@ func NewClient(cfg *Config, opts ...Option) *Client {
 	}
 
 	s := &Client{
-		cfg:     clientOptions,
-		span:    clientOptions.Span,
-		harSpan: clientOptions.HarSpan,
+		cfg:         clientOptions,
+		span:        clientOptions.Span,
+		harSpan:     clientOptions.HarSpan,
+		redactor:    newRedactor(clientOptions.Redaction),
+		tokenSource: clientOptions.TokenSource,
 	}
 
 	if clientOptions.TraceGroupName != "" {
@@ -72,68 +183,129 @@ func NewClient(cfg *Config, opts ...Option) *Client {
 	}
 
 	s.restClient = resty.New()
+	s.restClient.SetAllowGetMethodPayload(true)
+	s.restClient.SetPreRequestHook(setDroppedBody)
 
 	log.Trace().Bool("har-tracing-enabled", s.cfg.HarTracingEnabled).Msg(semLogContext)
 
-	if s.cfg.RestTimeout != 0 {
-		s.restClient.SetTimeout(s.cfg.RestTimeout)
-		log.Trace().Dur("rest-timeout", s.cfg.RestTimeout).Msg(semLogContext)
+	// Retries are driven by the retry policies of the client, not by resty.
+	rps, err := newRetryPolicies(&s.cfg)
+	if err != nil {
+		log.Error().Err(err).Msg(semLogContext + " invalid retry config... using the legacy retry settings")
+		rps, _ = newRetryPolicies(&Config{RetryCount: s.cfg.RetryCount, RetryWaitTime: s.cfg.RetryWaitTime, RetryMaxWaitTime: s.cfg.RetryMaxWaitTime, RetryOnHttpError: s.cfg.RetryOnHttpError})
+	}
+	s.retryPolicies = rps
+	log.Trace().Int("rest-retry-count", s.cfg.RetryCount).Interface("rest-retry on error", s.cfg.RetryOnHttpError).Msg(semLogContext)
+
+	var transport *transportSetup
+	switch {
+	case s.cfg.sharedTransport != nil:
+		transport = s.cfg.sharedTransport
+		s.useTransport(transport)
+	case s.cfg.HttpTransport != nil:
+		s.restClient.SetTransport(s.cfg.HttpTransport)
+	default:
+		ts, err := newTransport(&s.cfg)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext + " invalid transport config... using the default one")
+			ts, _ = newTransport(&Config{SkipVerify: s.cfg.SkipVerify})
+		}
+		transport = ts
+		s.useTransport(ts)
+		s.ownedTransport = ts
 	}
 
-	if s.cfg.RetryCount != 0 {
-		s.restClient.SetRetryCount(s.cfg.RetryCount)
-		log.Trace().Int("rest-retry-count", s.cfg.RetryCount).Msg(semLogContext)
+	if s.tokenSource == nil && clientOptions.OAuth2 != nil {
+		ts, err := newClientCredentialsTokenSource(clientOptions.OAuth2, tokenTransport(&s.cfg, transport))
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext + " unable to create the oauth2 token source... requests will not be authorized")
+		} else {
+			s.tokenSource = ts
+		}
 	}
 
-	if s.cfg.RetryWaitTime != 0 {
-		s.restClient.SetRetryWaitTime(s.cfg.RetryWaitTime)
-		log.Trace().Dur("rest-wait-time", s.cfg.RetryWaitTime).Msg(semLogContext)
+	switch {
+	case s.cfg.Jar != nil:
+		s.restClient.SetCookieJar(s.cfg.Jar)
+	case s.cfg.CookieJar != nil && s.cfg.CookieJar.Enabled:
+		jar, err := cookieJarForFile(s.cfg.CookieJar.FileName)
+		if err != nil {
+			log.Error().Err(err).Str("file-name", s.cfg.CookieJar.FileName).Msg(semLogContext + " unable to load cookie jar... using an empty one")
+			jar, _ = NewCookieJar("")
+		}
+		s.restClient.SetCookieJar(jar)
 	}
 
-	if s.cfg.RetryMaxWaitTime != 0 {
-		s.restClient.SetRetryMaxWaitTime(s.cfg.RetryMaxWaitTime)
-		log.Trace().Dur("rest-max-wait-time", s.cfg.RetryMaxWaitTime).Msg(semLogContext)
+	if s.tokenSource != nil {
+		s.redactSecretHeaders("Authorization")
 	}
 
-	if len(s.cfg.RetryOnHttpError) > 0 {
-		s.restClient.AddRetryCondition(retryCondition(s.cfg.RetryOnHttpError))
-		log.Trace().Interface("rest-retry on error", s.cfg.RetryOnHttpError).Msg(semLogContext)
+	switch {
+	case s.cfg.sharedCircuitBreakers != nil:
+		s.breakers = s.cfg.sharedCircuitBreakers
+	case s.cfg.CircuitBreaker != nil:
+		cbs, err := newCircuitBreakers(s.cfg.CircuitBreaker)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext + " invalid circuit breaker config... circuit breaker disabled")
+		}
+		s.breakers = cbs
 	}
 
-	if s.cfg.SkipVerify {
-		s.restClient.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+	switch {
+	case s.cfg.sharedRateLimiter != nil:
+		s.rateLimiter = s.cfg.sharedRateLimiter
+	case s.cfg.RateLimit != nil:
+		rl, err := newRateLimiter(s.cfg.RateLimit)
+		if err != nil {
+			log.Error().Err(err).Msg(semLogContext + " invalid rate limit config... rate limit disabled")
+		}
+		s.rateLimiter = rl
 	}
 
-	return s
-}
+	switch {
+	case s.cfg.sharedThrottle != nil:
+		s.throttle = s.cfg.sharedThrottle
+	case s.cfg.Throttle != nil:
+		s.throttle = newThrottle(s.cfg.Throttle)
+	}
 
-func retryCondition(errorsList []int) resty.RetryConditionFunc {
-	return func(resp *resty.Response, err error) bool {
+	if s.throttle != nil {
+		s.restClient.OnBeforeRequest(s.waitThrottle)
+		s.restClient.OnAfterResponse(s.observeQuota)
+	}
 
-		const semLogContext = "http-client::retry-condition-func"
+	if s.rateLimiter != nil {
+		s.restClient.OnBeforeRequest(s.waitRateLimit)
+	}
 
-		if len(errorsList) == 0 || err != nil {
-			log.Trace().Err(err).Msg(semLogContext + " retry condition satisfied")
-			return true
-		}
+	s.setAuth(s.cfg.Auth)
 
-		sc := resp.StatusCode()
-		for i := 0; i < len(errorsList); i++ {
-			if sc == errorsList[i] {
-				log.Trace().Int("http-status", sc).Msg(semLogContext + " retry condition satisfied")
-				return true
-			}
-		}
+	if s.cfg.MaxBodySize > 0 {
+		s.restClient.SetTransport(&bodyLimitTransport{next: s.restClient.GetClient().Transport})
+	}
 
-		log.Trace().Int("http-status", sc).Msg(semLogContext + " retry condition NOT satisfied")
-		return false
+	// The rest-timeout bounds each attempt through its context, so that an execution timeout can take its place.
+	if s.cfg.RestTimeout > 0 {
+		s.restClient.SetTransport(&attemptTimeoutTransport{next: s.restClient.GetClient().Transport})
+		log.Trace().Dur("rest-timeout", s.cfg.RestTimeout).Msg(semLogContext)
 	}
+
+	return s
 }
 
 func (s *Client) Close() {
 	if s.span != nil && s.spanOwned {
 		s.span.Finish()
 	}
+
+	if s.ownedTransport != nil {
+		s.ownedTransport.closeIdleConnections()
+	}
+}
+
+func (s *Client) useTransport(ts *transportSetup) {
+	s.restClient.SetTransport(ts.roundTripper())
+	s.proxyFunc = ts.proxyFunc
 }
 
 func (s *Client) NewRequest(method string, url string, body []byte, headers har.NameValuePairs, params har.NameValuePairs) (*har.Request, error) {
@@ -169,19 +341,40 @@ func (s *Client) NewRequest(method string, url string, body []byte, headers har.
 		}
 	*/
 
-	pars := make([]har.Param, 0)
-	for _, h := range params {
-		pars = append(pars, har.Param{Name: h.Name, Value: h.Value})
+	// The query of the url is kept untouched and the explicit params are appended, encoded, unless they are meant to be the fields
+	// of a form-urlencoded body.
+	baseUrl, rawQuery, err := splitUrl(url)
+	if err != nil {
+		return nil, err
 	}
 
-	bodySize := -1
+	var added har.NameValuePairs
+	isForm := isFormUrlEncoded(ct)
+	if !isForm {
+		added = params
+	}
+
+	qs := append(parseQueryString(rawQuery), added...)
+	url = joinUrl(baseUrl, rawQuery, added)
+
 	var postData *har.PostData
+	if isForm && len(body) == 0 && len(params) > 0 {
+		body = []byte(encodeQueryString(params))
+	}
+
 	if len(body) > 0 {
 		if ct == "" {
 			// Default content-type used if something else is not found.
 			ct = "application/json"
 		}
 
+		pars := make([]har.Param, 0)
+		if isForm {
+			for _, h := range params {
+				pars = append(pars, har.Param{Name: h.Name, Value: h.Value})
+			}
+		}
+
 		postData = &har.PostData{
 			MimeType: ct,
 			Data:     body,
@@ -189,15 +382,16 @@ func (s *Client) NewRequest(method string, url string, body []byte, headers har.
 		}
 	}
 
+	// the http version is overwritten on execution by the protocol actually negotiated, if a response comes.
 	req := &har.Request{
 		Method:      method,
 		URL:         url,
-		HTTPVersion: "1.1",
+		HTTPVersion: DefaultHTTPVersion,
 		Headers:     hs,
 		HeadersSize: -1,
-		Cookies:     []har.Cookie{},
-		QueryString: []har.NameValuePair{},
-		BodySize:    int64(bodySize),
+		Cookies:     harRequestCookies(hs),
+		QueryString: qs,
+		BodySize:    int64(len(body)),
 		PostData:    postData,
 	}
 
@@ -205,17 +399,134 @@ func (s *Client) NewRequest(method string, url string, body []byte, headers har.
 }
 
 func (s *Client) Execute(reqDef *har.Request, execOpts ...ExecutionContextOption) (*har.Entry, error) {
+	return s.ExecuteContext(context.Background(), reqDef, execOpts...)
+}
+
+// ExecuteContext is the context aware version of Execute: the in-flight request (retries included) is aborted as soon as ctx is canceled
+// or its deadline expires.
+func (s *Client) ExecuteContext(ctx context.Context, reqDef *har.Request, execOpts ...ExecutionContextOption) (*har.Entry, error) {
 
 	const semLogContext = "http-client::execute"
 
-	execCtx := ExecutionContext{}
+	ex := s.startExecution(ctx, reqDef, execOpts...)
+	if s.cfg.MaxBodySize > 0 {
+		ex.req.SetContext(withBodyLimit(ex.req.Context(), s.cfg.MaxBodySize))
+	}
+
+	resp, err := ex.send()
+
+	var sc int
+	var r *har.Response
+	switch {
+	case err == nil:
+		sc = resp.StatusCode()
+		r = ex.harResponse(resp)
+		r.BodySize = responseBodySize(resp.RawResponse, resp.Size())
+		r.Content.Size = resp.Size()
+		r.Content.Data = resp.Body()
+		if r.BodySize < resp.Size() {
+			r.Content.Compression = resp.Size() - r.BodySize
+		}
+
+		// A body larger than max-body-size is kept up to the limit: the size is the announced one, if any.
+		if tb, ok := resp.RawResponse.Body.(*truncatedBody); ok && tb.truncated {
+			log.Warn().Int("http-status", sc).Int64("max-body-size", s.cfg.MaxBodySize).Str("url", ex.url).Msg(semLogContext + " response body truncated")
+			r.BodySize, r.Content.Size, r.Content.Compression = responseBodySize(resp.RawResponse, -1), -1, 0
+			r.Content.Comment = fmt.Sprintf("%s: first %d bytes", HarContentTruncatedComment, resp.Size())
+			if cl := resp.RawResponse.ContentLength; cl >= 0 && resp.Header().Get("Content-Encoding") == "" {
+				r.BodySize, r.Content.Size = cl, cl
+				r.Content.Comment = fmt.Sprintf("%s: %d of %d bytes", HarContentTruncatedComment, resp.Size(), cl)
+			}
+		}
+
+	default:
+		sc, r, err = ex.errorResponse(resp, err)
+	}
+
+	ex.finish(r, sc, err)
+	return ex.entry, err
+}
+
+// ExecuteStream executes the request without buffering the response body that is returned as a stream. The caller has to close it: that is when
+// the entry gets completed (timings, sizes) and handed to the har span. The response content of the entry holds up to max-body-size bytes of
+// the body read so far (DefaultStreamHarMaxBodySize if not configured) and is marked as truncated if the body is larger.
+// In case of error the returned body is nil and the entry is already completed.
+func (s *Client) ExecuteStream(ctx context.Context, reqDef *har.Request, execOpts ...ExecutionContextOption) (*har.Entry, io.ReadCloser, error) {
+
+	ex := s.startExecution(ctx, reqDef, execOpts...)
+	ex.req.SetDoNotParseResponse(true)
+
+	resp, err := ex.send()
+	if err != nil {
+		sc, r, err := ex.errorResponse(resp, err)
+		ex.finish(r, sc, err)
+		return ex.entry, nil, err
+	}
+
+	if s.throttle != nil {
+		s.throttle.observe(requestHost(ex.url), resp.StatusCode(), resp.Header())
+	}
+
+	r := ex.harResponse(resp)
+	ex.entry.Response = r
+	return ex.entry, &streamBody{body: resp.RawBody(), ex: ex, response: r, statusCode: resp.StatusCode(), limit: s.harMaxBodySize()}, nil
+}
+
+// harMaxBodySize is the number of bytes of a streamed body, request or response, kept in the har entry.
+func (s *Client) harMaxBodySize() int64 {
+	if s.cfg.MaxBodySize > 0 {
+		return s.cfg.MaxBodySize
+	}
+
+	return DefaultStreamHarMaxBodySize
+}
+
+// execution holds the state of a request from its start to the completion of the har entry.
+type execution struct {
+	client  *Client
+	ctx     context.Context
+	execCtx ExecutionContext
+	reqDef  *har.Request
+	entry   *har.Entry
+
+	reqSpan opentracing.Span
+	harSpan hartracing.Span
+	timings *harTimingsTrace
+	req     *resty.Request
+	body    *rewindableBody
+	token   *Token
+	cancel  context.CancelFunc
+	circuit *circuitBreaker
+
+	// idempotencyKey is the key set by the execution, if any, for all its attempts.
+	idempotencyKey string
+
+	// circuitStates are the states of the circuit before and after the execution.
+	circuitStates [2]string
+
+	url    string
+	method string
+}
+
+func (s *Client) startExecution(ctx context.Context, reqDef *har.Request, execOpts ...ExecutionContextOption) *execution {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ex := &execution{client: s, ctx: ctx, reqDef: reqDef, timings: &harTimingsTrace{}}
 	for _, o := range execOpts {
-		o(&execCtx)
+		o(&ex.execCtx)
+	}
+
+	if ex.execCtx.Timeout > 0 {
+		ex.ctx, ex.cancel = context.WithTimeout(ctx, ex.execCtx.Timeout)
+		ctx = ex.ctx
 	}
 
 	now := time.Now()
-	e := &har.Entry{
-		Comment:         execCtx.RequestId,
+	ex.entry = &har.Entry{
+		Comment:         ex.execCtx.RequestId,
 		StartedDateTime: now.Format(time.RFC3339Nano),
 		StartDateTimeTm: now,
 		Request:         reqDef,
@@ -223,107 +534,304 @@ func (s *Client) Execute(reqDef *har.Request, execOpts ...ExecutionContextOption
 
 	var reqSpanName string
 	if s.cfg.TraceRequestName != "" {
-		reqSpanName = strings.Replace(s.cfg.TraceRequestName, RequestTraceNameOpNamePlaceHolder, execCtx.OpName, 1)
-		reqSpanName = strings.Replace(reqSpanName, RequestTraceNameRequestIdPlaceHolder, execCtx.RequestId, 1)
+		reqSpanName = strings.Replace(s.cfg.TraceRequestName, RequestTraceNameOpNamePlaceHolder, ex.execCtx.OpName, 1)
+		reqSpanName = strings.Replace(reqSpanName, RequestTraceNameRequestIdPlaceHolder, ex.execCtx.RequestId, 1)
 	} else {
-		reqSpanName = strings.Join([]string{execCtx.OpName, execCtx.RequestId}, "_")
+		reqSpanName = strings.Join([]string{ex.execCtx.OpName, ex.execCtx.RequestId}, "_")
 	}
 
-	reqSpan := s.startSpan(s.span, execCtx.Span, reqSpanName)
-	defer reqSpan.Finish()
+	ex.reqSpan = s.startSpan(s.span, ex.execCtx.Span, reqSpanName)
 
 	// create a har-span and set a tag in the opentracing span.... if hartracing has been enabled...
-	var harSpan hartracing.Span
 	if s.cfg.IsHarTracingEnabled() {
-		harSpan = s.startHarSpan(s.harSpan, execCtx.HarSpan)
-		defer harSpan.Finish()
-		reqSpan.SetTag(hartracing.HARTraceOpenTracingTagName, harSpan.Id())
+		ex.harSpan = s.startHarSpan(s.harSpan, ex.execCtx.HarSpan)
+		ex.reqSpan.SetTag(hartracing.HARTraceOpenTracingTagName, ex.harSpan.Id())
 	}
 
 	// reqDef.Headers = append(reqDef.Headers, NameValuePair{Name: "Accept", Value: "application/json"})
-	req := s.getRequestWithSpans(reqDef, reqSpan, harSpan)
+	ex.req = s.getRequestWithSpans(reqDef, ex.reqSpan, ex.harSpan)
+
+	var bodyReader io.Reader
+	if ex.execCtx.Body != nil {
+		ex.body = &rewindableBody{factory: ex.execCtx.Body, limit: s.harMaxBodySize()}
+		ex.req.SetBody(ex.body)
+		bodyReader = ex.body
+	}
+
+	ex.method = reqDef.Method
+	if ex.method == "" {
+		ex.method = http.MethodGet
+	}
+
+	ctx = context.WithValue(ctx, executionTimingsKey{}, ex.timings)
+	if ex.execCtx.Timeout <= 0 && s.cfg.RestTimeout > 0 {
+		ctx = withAttemptTimeout(ctx, s.cfg.RestTimeout)
+	}
+	ctx = withDroppedBody(ctx, ex.method, reqDef, bodyReader)
+	ex.req.SetContext(httptrace.WithClientTrace(ctx, ex.timings.clientTrace()))
+
+	ex.setIdempotencyKey()
+	return ex
+}
+
+func (ex *execution) send() (*resty.Response, error) {
 
-	var resp *resty.Response
 	var err error
+	ex.url, err = requestUrl(ex.reqDef)
+	if err == nil {
+		// Any method, standard or extension one (PROPFIND, REPORT, ...), goes through the same path: the only requirement is a well-formed token.
+		err = validateMethod(ex.method)
+	}
+
+	if err != nil {
+		ex.url = ex.reqDef.URL
+		return nil, err
+	}
+
+	ex.circuit = ex.client.breakers.circuit(ex.url)
+	if ex.circuit == nil {
+		return ex.sendAuthorized()
+	}
+
+	generation, err := ex.circuit.allow()
+	if err != nil {
+		st := ex.circuit.currentState()
+		ex.circuitStates = [2]string{st, st}
+		return nil, err
+	}
+
+	resp, err := ex.sendAuthorized()
+	ex.circuitStates[0], ex.circuitStates[1] = ex.circuit.record(generation, ex.client.breakers.isFailure(resp, err))
+	return resp, err
+}
+
+// sendAuthorized executes the request, retries included, trying once more with a new token if the current one is rejected.
+func (ex *execution) sendAuthorized() (*resty.Response, error) {
+
+	const semLogContext = "http-client::execute"
+
+	if err := ex.authorize(nil); err != nil {
+		return nil, err
+	}
+
+	resp, err := ex.execute()
+	if err != nil || resp.StatusCode() != http.StatusUnauthorized || ex.client.tokenSource == nil {
+		return resp, err
+	}
+
+	// A streamed body that cannot be sent again leaves the 401 as the final answer.
+	if ex.body != nil {
+		if rerr := ex.body.rewind(); rerr != nil {
+			log.Warn().Err(rerr).Str("url", ex.url).Msg(semLogContext + " request not authorized... body cannot be rewound for a refreshed token")
+			return resp, nil
+		}
+	}
+
+	// The token may have been revoked before its expiry: the request is tried once more with a brand new one.
+	log.Warn().Str("url", ex.url).Msg(semLogContext + " request not authorized... retrying with a refreshed token")
+	if resp.RawResponse != nil {
+		_ = resp.RawResponse.Body.Close()
+	}
 
-	u := reqDef.URL
-	switch reqDef.Method {
-	case http.MethodGet:
-		resp, err = req.Get(u)
-	case http.MethodHead:
-		resp, err = req.Head(u)
-	case http.MethodPost:
-		resp, err = req.Post(u)
-	case http.MethodPut:
-		resp, err = req.Put(u)
-	case http.MethodDelete:
-		resp, err = req.Delete(u)
-	case http.MethodPatch:
-		resp, err = req.Patch(u)
+	if err := ex.authorize(ex.token); err != nil {
+		return resp, err
 	}
 
+	return ex.execute()
+}
+
+// execute sends the request retrying it as long as the retry policy of the execution says so. A request with a non idempotent method is retried
+// only if it carries an idempotency key.
+func (ex *execution) execute() (*resty.Response, error) {
+
+	const semLogContext = "http-client::execute"
+
+	p := ex.client.retryPolicies.policy(ex.execCtx.OpName)
+	if p != nil {
+		ex.reqSpan.SetTag(RetryPolicyTraceTag, p.name)
+	}
+
+	var wait time.Duration
+	for attempt := 0; ; attempt++ {
+		resp, err := ex.req.Execute(ex.method, ex.url)
+		if p == nil || attempt >= p.cfg.RetryCount || ex.ctx.Err() != nil {
+			return resp, err
+		}
+
+		reason := p.retryReason(resp, err)
+		if reason == "" {
+			return resp, err
+		}
+
+		if !ex.retryable() {
+			log.Debug().Str("url", ex.url).Str("method", ex.method).Str("reason", reason).Msg(semLogContext + " non idempotent request without an idempotency key... not retried")
+			return resp, err
+		}
+
+		// A streamed request body gets re-opened for the next attempt. If it cannot, the outcome of this attempt is the final one.
+		if ex.body != nil {
+			if rerr := ex.body.rewind(); rerr != nil {
+				log.Debug().Err(rerr).Str("url", ex.url).Str("reason", reason).Msg(semLogContext + " request body cannot be rewound... not retried")
+				return resp, err
+			}
+		}
+
+		wait = p.wait(attempt, wait, resp)
+		log.Trace().Err(err).Str("url", ex.url).Str("reason", reason).Int("attempt", attempt+1).Dur("wait", wait).Msg(semLogContext + " retrying")
+		ex.reqSpan.LogKV("event", "retry", "attempt", attempt+1, "reason", reason, "wait", wait.String())
+
+		// The response that is going to be retried is discarded: in streaming mode its body has not been read and has to be closed to release the connection.
+		if resp != nil && resp.RawResponse != nil {
+			_ = resp.RawResponse.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ex.ctx.Done():
+			timer.Stop()
+			return resp, ex.ctx.Err()
+		}
+	}
+}
+
+// authorize sets the Authorization header with the token of the token source of the client, if any.
+func (ex *execution) authorize(rejected *Token) error {
+	if ex.client.tokenSource == nil {
+		return nil
+	}
+
+	tok, err := ex.client.tokenSource.Token(ex.ctx, rejected)
+	if err != nil {
+		if !errors.Is(err, ErrTokenSource) {
+			err = fmt.Errorf("%w: %v", ErrTokenSource, err)
+		}
+		return err
+	}
+
+	ex.token = tok
+	ex.req.SetHeader("Authorization", tok.TokenType+" "+tok.AccessToken)
+	return nil
+}
+
+// harResponse creates the response of the entry out of status and headers. The content is left to the caller.
+func (ex *execution) harResponse(resp *resty.Response) *har.Response {
+
+	r := &har.Response{
+		Status:      resp.StatusCode(),
+		HTTPVersion: resp.RawResponse.Proto,
+		StatusText:  resp.Status(),
+		HeadersSize: responseHeadersSize(resp.RawResponse),
+		Headers:     harHeaders(resp.Header()),
+		Cookies:     harCookies(resp.Cookies()),
+		Content: &har.Content{
+			MimeType: resp.Header().Get("Content-type"),
+		},
+	}
+
+	ex.reqDef.HTTPVersion = resp.RawResponse.Proto
+	ex.reqSpan.SetTag(HttpVersionTraceTag, resp.RawResponse.Proto)
+	ex.reqDef.HeadersSize = requestHeadersSize(resp.Request.RawRequest, resp.RawResponse.ProtoMajor)
+	if resp.Request.RawRequest != nil {
+		if resp.Request.RawRequest.ContentLength >= 0 {
+			ex.reqDef.BodySize = resp.Request.RawRequest.ContentLength
+		}
+
+		// The cookies actually sent include the ones added from the jar.
+		ex.reqDef.Cookies = harCookies(resp.Request.RawRequest.Cookies())
+	}
+
+	return r
+}
+
+func (ex *execution) errorResponse(resp *resty.Response, err error) (int, *har.Response, error) {
+
+	const semLogContext = "http-client::execute"
+
 	var sc int
 	var st string
 	if resp != nil {
+		log.Warn().Msg(semLogContext + " error is not nil but response is present... compare to symphony behaviour.. v0.0.15")
 		sc = resp.StatusCode()
-		st = resp.Status()
 	}
 
-	var r *har.Response
-	if err == nil {
+	if isTimeoutError(err) {
+		phase := ex.timings.pendingPhase()
+		ex.reqSpan.SetTag(TimeoutPhaseTraceTag, phase)
+		err = &TimeoutError{Phase: phase, Err: err}
+	}
 
-		r = &har.Response{
-			Status:      sc,
-			HTTPVersion: "1.1",
-			StatusText:  st,
-			HeadersSize: -1,
-			Headers:     []har.NameValuePair{},
-			BodySize:    resp.Size(),
-			Cookies:     []har.Cookie{},
-			Content: &har.Content{
-				MimeType: resp.Header().Get("Content-type"),
-				Size:     resp.Size(),
-				Data:     resp.Body(),
-			},
-		}
+	sc, st = DetectStatusCodeStatusTextFromError(sc, err)
+	err = util.NewError(strconv.Itoa(sc), err)
+	r := har.NewResponse(sc, st, "text/plain", []byte(err.Error()), nil)
+	r.HTTPVersion = DefaultHTTPVersion
+	if resp != nil && resp.RawResponse != nil {
+		r.HTTPVersion = resp.RawResponse.Proto
+		ex.reqSpan.SetTag(HttpVersionTraceTag, r.HTTPVersion)
+	}
+	if ex.ctx.Err() != nil {
+		log.Warn().Err(ex.ctx.Err()).Str("url", ex.url).Msg(semLogContext + " request aborted by context")
+		r.Comment = ex.ctx.Err().Error()
+		ex.reqSpan.SetTag(ContextErrorTraceTag, ex.ctx.Err().Error())
+	}
 
-		for n, _ := range resp.Header() {
-			r.Headers = append(r.Headers, har.NameValuePair{Name: n, Value: resp.Header().Get(n)})
-		}
-	} else {
-		if resp != nil {
-			log.Warn().Msg(semLogContext + " error is not nil but response is present... compare to symphony behaviour.. v0.0.15")
-		}
-		sc, st = DetectStatusCodeStatusTextFromError(sc, err)
-		err = util.NewError(strconv.Itoa(sc), err)
-		r = har.NewResponse(sc, st, "text/plain", []byte(err.Error()), nil)
+	return sc, r, err
+}
+
+// finish completes the entry, hands it to the har span and closes the spans of the request.
+func (ex *execution) finish(r *har.Response, sc int, err error) {
+
+	ex.timings.finish()
+
+	if ex.body != nil {
+		// The entry gets a copy of the request to avoid the preview to become the body of a subsequent execution of the same request.
+		ex.body.close()
+		harReq := *ex.reqDef
+		harReq.PostData = ex.body.harPostData(ex.reqDef.Headers.GetFirst("content-type").Value)
+		harReq.BodySize = ex.body.size
+		ex.entry.Request = &harReq
 	}
 
-	s.setSpanTags(reqSpan, execCtx.OpName, execCtx.RequestId, execCtx.LRAId, u, reqDef.Method, sc, err)
+	ex.harIdempotencyKey()
+	ex.client.setSpanTags(ex.reqSpan, ex.execCtx.OpName, ex.execCtx.RequestId, ex.execCtx.LRAId, ex.url, ex.reqDef.Method, sc, err)
+	if p := ex.client.proxyFor(ex.url); p != "" {
+		// the comment carries the request id: the proxy is appended to it.
+		if ex.entry.Comment != "" {
+			ex.entry.Comment += "; "
+		}
+		ex.entry.Comment += fmt.Sprintf("proxy: %s", p)
+		ex.reqSpan.SetTag(ProxyTraceTag, p)
+	}
 
-	if e.StartedDateTime != "" {
-		elapsed := time.Since(e.StartDateTimeTm)
-		e.Time = float64(elapsed.Milliseconds())
+	if ex.circuit != nil {
+		ex.reqSpan.SetTag(CircuitStateTraceTag, ex.circuitStates[1])
+		if ex.circuitStates[0] != ex.circuitStates[1] {
+			ex.reqSpan.LogKV("event", "circuit-state-transition", "circuit", ex.circuit.key, "from", ex.circuitStates[0], "to", ex.circuitStates[1])
+		}
 	}
 
-	e.Timings = &har.Timings{
-		Blocked: -1,
-		DNS:     -1,
-		Connect: -1,
-		Send:    -1,
-		Wait:    e.Time,
-		Receive: -1,
-		Ssl:     -1,
+	if serial := ex.timings.clientCertSerial(); serial != "" {
+		ex.reqSpan.SetTag(TLSClientCertSerialTraceTag, serial)
 	}
 
-	e.Response = r
+	elapsed := time.Since(ex.entry.StartDateTimeTm)
+	ex.entry.Time = millis(elapsed)
+	ex.entry.Timings = ex.timings.harTimings(elapsed)
+	ex.entry.ServerIPAddress = ex.timings.serverIPAddress()
+	ex.entry.Connection = ex.timings.connection()
+
+	ex.entry.Response = r
 
-	if harSpan != nil {
-		harSpan.AddEntry(e)
+	if ex.harSpan != nil {
+		ex.harSpan.AddEntry(ex.client.redactor.redactEntry(ex.entry))
+		ex.harSpan.Finish()
 	}
 
-	return e, err
-	// return resp.StatusCode(), resp.Body(), resp.Header(), err
+	ex.reqSpan.Finish()
+
+	if ex.cancel != nil {
+		ex.cancel()
+	}
 }
 
 func (s *Client) getRequestWithSpans(reqDef *har.Request, reqSpan opentracing.Span, reqHarSpan hartracing.Span) *resty.Request {
@@ -336,18 +844,9 @@ func (s *Client) getRequestWithSpans(reqDef *har.Request, reqSpan opentracing.Sp
 		_ = hartracing.GlobalTracer().Inject(reqHarSpan.Context(), hartracing.HTTPHeadersCarrier(req.Header))
 	}
 
-	switch reqDef.Method {
-	case http.MethodGet:
-	case http.MethodHead:
-	case http.MethodDelete:
-	case http.MethodPost:
-		fallthrough
-	case http.MethodPut:
-		fallthrough
-	case http.MethodPatch:
-		if reqDef.HasBody() {
-			req = req.SetBody(reqDef.PostData.Data)
-		}
+	// The body is honoured whatever the method. Resty drops it for HEAD and OPTIONS requests: the pre-request hook sends it.
+	if reqDef.HasBody() {
+		req = req.SetBody(reqDef.PostData.Data)
 	}
 
 	// Setting more specific headers next
@@ -355,10 +854,6 @@ func (s *Client) getRequestWithSpans(reqDef *har.Request, reqSpan opentracing.Sp
 		req.SetHeader(h.Name, h.Value)
 	}
 
-	for _, h := range reqDef.QueryString {
-		req.SetQueryParam(h.Name, h.Value)
-	}
-
 	return req
 }
 
@@ -434,7 +929,8 @@ func (s *Client) startSpan(groupParentSpan, requestParentSpan opentracing.Span,
 
 func (s *Client) setSpanTags(reqSpan opentracing.Span, opName, reqId, lraId, endpoint, method string, statusCode int, err error) {
 
-	reqSpan.SetTag(util.HttpUrlTraceTag, endpoint)
+	redactedEndpoint := s.redactor.redactUrl(endpoint)
+	reqSpan.SetTag(util.HttpUrlTraceTag, redactedEndpoint)
 	reqSpan.SetTag(util.HttpMethodTraceTag, method)
 	reqSpan.SetTag(util.HttStatusCodeTraceTag, statusCode)
 
@@ -451,16 +947,78 @@ func (s *Client) setSpanTags(reqSpan opentracing.Span, opName, reqId, lraId, end
 	}
 
 	if err != nil {
-		reqSpan.SetTag("error", err.Error())
+		reqSpan.SetTag("error", strings.ReplaceAll(err.Error(), endpoint, redactedEndpoint))
 		ext.Error.Set(reqSpan, true)
 	}
 }
 
+var ErrInvalidMethod = errors.New("invalid http method")
+
+// validateMethod checks the method is a valid token as per RFC 9110 section 9.1: extension methods are fine, malformed names are not.
+func validateMethod(m string) error {
+	if m == "" {
+		return fmt.Errorf("%w: empty method name", ErrInvalidMethod)
+	}
+
+	for i := 0; i < len(m); i++ {
+		c := m[i]
+		isTChar := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+		if !isTChar {
+			return fmt.Errorf("%w: %q", ErrInvalidMethod, m)
+		}
+	}
+
+	return nil
+}
+
+const (
+	// DefaultHTTPVersion is the http version of the har entries whose request did not get a response.
+	DefaultHTTPVersion = "HTTP/1.1"
+
+	// StatusClientClosedRequest is the non-standard (nginx) status used when the caller gave up on the request by canceling its context.
+	StatusClientClosedRequest     = 499
+	StatusTextClientClosedRequest = "Client Closed Request"
+
+	// StatusCertificateError is the non-standard (nginx) status used when the server certificate does not match the pins of the service.
+	StatusCertificateError           = 495
+	StatusTextCertificatePinMismatch = "Certificate Pin Mismatch"
+
+	// StatusTextCircuitOpen is the text of the 503 status of the requests failed fast by an open circuit breaker.
+	StatusTextCircuitOpen = "Circuit Open"
+
+	// StatusTextRateLimited is the text of the 429 status of the requests not sent for the client side rate limit.
+	StatusTextRateLimited = "Client Rate Limit Exceeded"
+)
+
 func DetectStatusCodeStatusTextFromError(c int, err error) (int, string) {
 	if c != 0 {
 		return c, http.StatusText(c)
 	}
 
+	if errors.Is(err, ErrInvalidMethod) {
+		return http.StatusBadRequest, ErrInvalidMethod.Error()
+	}
+
+	if errors.Is(err, ErrTokenSource) {
+		return http.StatusUnauthorized, ErrTokenSource.Error()
+	}
+
+	if errors.Is(err, ErrCertificatePinMismatch) {
+		return StatusCertificateError, StatusTextCertificatePinMismatch
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		return http.StatusTooManyRequests, StatusTextRateLimited
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		return http.StatusServiceUnavailable, StatusTextCircuitOpen
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return StatusClientClosedRequest, StatusTextClientClosedRequest
+	}
+
 	if errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err) {
 		return http.StatusRequestTimeout, http.StatusText(http.StatusRequestTimeout)
 	}
@@ -0,0 +1,266 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	RetryBackoffExponential        = "exponential"
+	RetryBackoffDecorrelatedJitter = "decorrelated-jitter"
+	RetryBackoffConstant           = "constant"
+
+	RetryErrorAny     = "any"
+	RetryErrorTimeout = "timeout"
+	RetryErrorReset   = "reset"
+	RetryErrorDNS     = "dns"
+	RetryErrorConnect = "connect"
+
+	// LegacyRetryPolicyName is the name of the policy made out of the retry-count, retry-wait-time, retry-max-wait-time and retry-on-errors settings.
+	LegacyRetryPolicyName = "legacy"
+
+	DefaultRetryWaitTime    = 100 * time.Millisecond
+	DefaultRetryMaxWaitTime = 2 * time.Second
+)
+
+var ErrRetryConfig = errors.New("invalid retry config")
+
+// RetryPredicate tells whether a response has to be retried. The body is nil for the streamed responses.
+type RetryPredicate func(resp *http.Response, body []byte) bool
+
+// RetryConfig defines named retry policies. Policy is the one used by default and Ops overrides it for the executions whose op-name is listed.
+// Without a default policy the executions not listed in Ops follow the legacy retry settings of the Config.
+type RetryConfig struct {
+	Policy   string                        `mapstructure:"policy,omitempty" json:"policy,omitempty" yaml:"policy,omitempty"`
+	Policies map[string]*RetryPolicyConfig `mapstructure:"policies,omitempty" json:"policies,omitempty" yaml:"policies,omitempty"`
+	Ops      map[string]string             `mapstructure:"ops,omitempty" json:"ops,omitempty" yaml:"ops,omitempty"`
+}
+
+// RetryPolicyConfig retries up to RetryCount times the attempts failed with one of the StatusCodes, with an error of one of the Errors classes
+// (timeout, reset, dns, connect or any) or for which one of the Predicates holds. The wait between attempts is given by the Backoff, exponential
+// with jitter (the default), decorrelated-jitter or constant, starting from WaitTime and capped by MaxWaitTime; a Retry-After asked by the server
+// on a 429 or 503 takes its place, capped the same way. Whatever the policy, a non idempotent request is retried only if it carries an idempotency key.
+type RetryPolicyConfig struct {
+	Backoff     string           `mapstructure:"backoff,omitempty" json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	RetryCount  int              `mapstructure:"retry-count,omitempty" json:"retry-count,omitempty" yaml:"retry-count,omitempty"`
+	WaitTime    time.Duration    `mapstructure:"wait-time,omitempty" json:"wait-time,omitempty" yaml:"wait-time,omitempty"`
+	MaxWaitTime time.Duration    `mapstructure:"max-wait-time,omitempty" json:"max-wait-time,omitempty" yaml:"max-wait-time,omitempty"`
+	StatusCodes []int            `mapstructure:"status-codes,omitempty" json:"status-codes,omitempty" yaml:"status-codes,omitempty"`
+	Errors      []string         `mapstructure:"errors,omitempty" json:"errors,omitempty" yaml:"errors,omitempty"`
+	Predicates  []RetryPredicate `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+type retryPolicy struct {
+	name string
+	cfg  RetryPolicyConfig
+
+	errorClasses map[string]bool
+}
+
+func newRetryPolicy(name string, cfg *RetryPolicyConfig) (*retryPolicy, error) {
+
+	p := &retryPolicy{name: name, cfg: *cfg, errorClasses: make(map[string]bool)}
+	switch p.cfg.Backoff {
+	case "":
+		p.cfg.Backoff = RetryBackoffExponential
+	case RetryBackoffExponential, RetryBackoffDecorrelatedJitter, RetryBackoffConstant:
+	default:
+		return nil, fmt.Errorf("%w: unsupported backoff %s in policy %s", ErrRetryConfig, p.cfg.Backoff, name)
+	}
+
+	if p.cfg.WaitTime <= 0 {
+		p.cfg.WaitTime = DefaultRetryWaitTime
+	}
+
+	if p.cfg.MaxWaitTime <= 0 {
+		p.cfg.MaxWaitTime = DefaultRetryMaxWaitTime
+	}
+
+	if p.cfg.MaxWaitTime < p.cfg.WaitTime {
+		p.cfg.MaxWaitTime = p.cfg.WaitTime
+	}
+
+	for _, c := range p.cfg.Errors {
+		switch c {
+		case RetryErrorAny, RetryErrorTimeout, RetryErrorReset, RetryErrorDNS, RetryErrorConnect:
+			p.errorClasses[c] = true
+		default:
+			return nil, fmt.Errorf("%w: unsupported error class %s in policy %s", ErrRetryConfig, c, name)
+		}
+	}
+
+	return p, nil
+}
+
+// retryPolicies resolves the policy of an execution out of its op-name.
+type retryPolicies struct {
+	def *retryPolicy
+	ops map[string]*retryPolicy
+}
+
+func newRetryPolicies(cfg *Config) (*retryPolicies, error) {
+
+	rps := &retryPolicies{ops: make(map[string]*retryPolicy)}
+
+	// The legacy settings retry on any error and on the listed status codes.
+	if cfg.RetryCount > 0 {
+		legacy := &RetryPolicyConfig{
+			RetryCount:  cfg.RetryCount,
+			WaitTime:    cfg.RetryWaitTime,
+			MaxWaitTime: cfg.RetryMaxWaitTime,
+			StatusCodes: cfg.RetryOnHttpError,
+			Errors:      []string{RetryErrorAny},
+		}
+		rps.def, _ = newRetryPolicy(LegacyRetryPolicyName, legacy)
+	}
+
+	if cfg.Retry == nil {
+		return rps, nil
+	}
+
+	policies := make(map[string]*retryPolicy)
+	for n, pcfg := range cfg.Retry.Policies {
+		if pcfg == nil {
+			return nil, fmt.Errorf("%w: empty policy %s", ErrRetryConfig, n)
+		}
+
+		p, err := newRetryPolicy(n, pcfg)
+		if err != nil {
+			return nil, err
+		}
+		policies[n] = p
+	}
+
+	lookup := func(n string) (*retryPolicy, error) {
+		p, ok := policies[n]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown policy %s", ErrRetryConfig, n)
+		}
+		return p, nil
+	}
+
+	if cfg.Retry.Policy != "" {
+		p, err := lookup(cfg.Retry.Policy)
+		if err != nil {
+			return nil, err
+		}
+		rps.def = p
+	}
+
+	for op, n := range cfg.Retry.Ops {
+		p, err := lookup(n)
+		if err != nil {
+			return nil, err
+		}
+		rps.ops[op] = p
+	}
+
+	return rps, nil
+}
+
+// policy returns the policy of the op, nil if the execution is not to be retried.
+func (rps *retryPolicies) policy(opName string) *retryPolicy {
+	if rps == nil {
+		return nil
+	}
+
+	if p, ok := rps.ops[opName]; ok {
+		return p
+	}
+
+	return rps.def
+}
+
+// retryReason returns why the outcome of an attempt has to be retried, an empty string if it does not.
+func (p *retryPolicy) retryReason(resp *resty.Response, err error) string {
+
+	if err != nil {
+		// the errors retrying cannot fix.
+		if errors.Is(err, context.Canceled) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrTokenSource) || errors.Is(err, ErrBodyNotRewindable) ||
+			errors.Is(err, ErrCertificatePinMismatch) {
+			return ""
+		}
+
+		c := errorClass(err)
+		if p.errorClasses[RetryErrorAny] || (c != "" && p.errorClasses[c]) {
+			if c == "" {
+				return RetryErrorAny
+			}
+			return c
+		}
+
+		return ""
+	}
+
+	sc := resp.StatusCode()
+	for _, c := range p.cfg.StatusCodes {
+		if sc == c {
+			return fmt.Sprintf("status %d", sc)
+		}
+	}
+
+	for _, pred := range p.cfg.Predicates {
+		if pred(resp.RawResponse, resp.Body()) {
+			return "predicate"
+		}
+	}
+
+	return ""
+}
+
+// errorClass classifies a transport error as timeout, reset, dns or connect. Other errors have no class.
+func errorClass(err error) string {
+
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case isTimeoutError(err):
+		return RetryErrorTimeout
+	case errors.As(err, &dnsErr):
+		return RetryErrorDNS
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return RetryErrorReset
+	case errors.Is(err, syscall.ECONNREFUSED) || errors.As(err, &opErr) && opErr.Op == "dial":
+		return RetryErrorConnect
+	}
+
+	return ""
+}
+
+// wait returns the time to wait before the retry following the attempt (0 based) given the previous wait.
+func (p *retryPolicy) wait(attempt int, prev time.Duration, resp *resty.Response) time.Duration {
+
+	if resp != nil {
+		if d := retryAfter(resp); d > 0 {
+			return min(d, p.cfg.MaxWaitTime)
+		}
+	}
+
+	base, maxWait := p.cfg.WaitTime, p.cfg.MaxWaitTime
+	switch p.cfg.Backoff {
+	case RetryBackoffConstant:
+		return base
+
+	case RetryBackoffDecorrelatedJitter:
+		// sleep = min(cap, random_between(base, prev * 3))
+		hi := max(prev*3, base)
+		return min(maxWait, base+rand.N(hi-base+1))
+	}
+
+	// capped exponential backoff with equal jitter.
+	d := maxWait
+	if attempt < 62 && base<<attempt > 0 && base<<attempt < maxWait {
+		d = base << attempt
+	}
+
+	half := d / 2
+	return max(base, half+rand.N(half+1))
+}
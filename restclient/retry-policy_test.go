@@ -0,0 +1,105 @@
+package restclient_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicies(t *testing.T) {
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt64(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			_, _ = w.Write([]byte(`{"outcome": "retry-later"}`))
+		default:
+			_, _ = w.Write([]byte(`{"outcome": "ok"}`))
+		}
+	}))
+	defer srv.Close()
+
+	// a port nobody listens to.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refused := "http://" + ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	retryLater := func(_ *http.Response, body []byte) bool {
+		return bytes.Contains(body, []byte("retry-later"))
+	}
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{
+		Retry: &restclient.RetryConfig{
+			Policy: "standard",
+			Policies: map[string]*restclient.RetryPolicyConfig{
+				"standard": {Backoff: restclient.RetryBackoffConstant, RetryCount: 3, WaitTime: 50 * time.Millisecond, StatusCodes: []int{http.StatusServiceUnavailable}, Errors: []string{restclient.RetryErrorConnect}, Predicates: []restclient.RetryPredicate{retryLater}},
+				"jitter":   {Backoff: restclient.RetryBackoffDecorrelatedJitter, RetryCount: 2, WaitTime: 10 * time.Millisecond, MaxWaitTime: 20 * time.Millisecond, Errors: []string{restclient.RetryErrorTimeout}},
+				"none":     {},
+			},
+			Ops: map[string]string{"get-balance": "none", "get-rates": "jitter"},
+		},
+	})
+	require.NoError(t, err)
+
+	client, err := lks.NewClient()
+	require.NoError(t, err)
+	defer client.Close()
+
+	retries := func() int {
+		spans := tracer.FinishedSpans()
+		return len(spans[len(spans)-1].Logs())
+	}
+
+	// the 503 and the response matching the predicate are retried.
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+	start := time.Now()
+	harEntry, err := client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, `{"outcome": "ok"}`, string(harEntry.Response.Content.Data))
+	require.Equal(t, int64(3), atomic.LoadInt64(&hits))
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.Equal(t, 2, retries())
+	spans := tracer.FinishedSpans()
+	require.Equal(t, "standard", spans[len(spans)-1].Tag(restclient.RetryPolicyTraceTag))
+
+	// the op override does not retry.
+	atomic.StoreInt64(&hits, 0)
+	harEntry, err = client.Execute(request, restclient.ExecutionWithOpName("get-balance"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, harEntry.Response.Status)
+	require.Equal(t, int64(1), atomic.LoadInt64(&hits))
+
+	// the error classes.
+	request, err = client.NewRequest(http.MethodGet, refused, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = client.Execute(request)
+	require.Error(t, err)
+	require.Equal(t, 3, retries())
+
+	_, err = client.Execute(request, restclient.ExecutionWithOpName("get-rates"))
+	require.Error(t, err)
+	require.Equal(t, 0, retries())
+
+	_, err = restclient.NewInstanceWithConfig(&restclient.Config{Retry: &restclient.RetryConfig{Ops: map[string]string{"get-balance": "missing"}}})
+	require.True(t, errors.Is(err, restclient.ErrRetryConfig), err)
+
+	_, err = restclient.NewInstanceWithConfig(&restclient.Config{Retry: &restclient.RetryConfig{Policies: map[string]*restclient.RetryPolicyConfig{"p": {Errors: []string{"tls"}}}}})
+	require.True(t, errors.Is(err, restclient.ErrRetryConfig), err)
+}
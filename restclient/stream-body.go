@@ -0,0 +1,176 @@
+package restclient
+
+import (
+	"errors"
+	"fmt"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"io"
+	"sync"
+)
+
+const (
+	DefaultStreamHarMaxBodySize = 64 * 1024
+	HarContentTruncatedComment  = "truncated"
+)
+
+// streamBody is the body returned by ExecuteStream. While the caller reads it, up to limit bytes are copied in the content of the har entry
+// which is completed on Close.
+type streamBody struct {
+	body       io.ReadCloser
+	ex         *execution
+	response   *har.Response
+	statusCode int
+	limit      int64
+
+	size    int64
+	preview []byte
+	readErr error
+	once    sync.Once
+}
+
+func (sb *streamBody) Read(p []byte) (int, error) {
+	n, err := sb.body.Read(p)
+	if n > 0 {
+		sb.size += int64(n)
+		if room := sb.limit - int64(len(sb.preview)); room > 0 {
+			sb.preview = append(sb.preview, p[:min(int64(n), room)]...)
+		}
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) && sb.readErr == nil {
+		sb.readErr = err
+	}
+
+	return n, err
+}
+
+func (sb *streamBody) Close() error {
+	err := sb.body.Close()
+	sb.once.Do(sb.complete)
+	return err
+}
+
+func (sb *streamBody) complete() {
+
+	r := sb.response
+	r.BodySize = sb.size
+	r.Content.Size = sb.size
+	r.Content.Data = sb.preview
+	if sb.size > int64(len(sb.preview)) {
+		r.Content.Comment = fmt.Sprintf("%s: %d of %d bytes", HarContentTruncatedComment, len(sb.preview), sb.size)
+	}
+
+	if sb.readErr != nil {
+		r.Comment = sb.readErr.Error()
+	}
+
+	sb.ex.finish(r, sb.statusCode, sb.readErr)
+}
+
+var ErrBodyNotRewindable = errors.New("request body cannot be rewound for a retry")
+
+// BodyFactory provides a fresh reader of the request body each time it is invoked, one per attempt.
+type BodyFactory func() (io.ReadCloser, error)
+
+// BodyFromReader adapts a reader to a BodyFactory. An io.Seeker is rewound at each attempt, any other reader can be sent only once: the
+// request is not retried and the outcome of its first attempt is returned.
+func BodyFromReader(r io.Reader) BodyFactory {
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return func() (io.ReadCloser, error) {
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(rs), nil
+		}
+	}
+
+	used := false
+	return func() (io.ReadCloser, error) {
+		if used {
+			return nil, ErrBodyNotRewindable
+		}
+		used = true
+		return io.NopCloser(r), nil
+	}
+}
+
+// rewindableBody is the request body set on the resty request when a BodyFactory has been provided. The same object is sent by every attempt
+// so it is rewound by the execution before a new one, a retry or the replay after a 401. It deliberately does not implement io.Closer so that the transport does not close it between attempts.
+// Up to limit bytes of the last attempt are kept as a preview for the har entry.
+type rewindableBody struct {
+	mu      sync.Mutex
+	factory BodyFactory
+	current io.ReadCloser
+	limit   int64
+	size    int64
+	preview []byte
+}
+
+func (rb *rewindableBody) Read(p []byte) (int, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.current == nil {
+		rc, err := rb.factory()
+		if err != nil {
+			return 0, err
+		}
+		rb.current = rc
+	}
+
+	n, err := rb.current.Read(p)
+	if n > 0 {
+		rb.size += int64(n)
+		if room := rb.limit - int64(len(rb.preview)); room > 0 {
+			rb.preview = append(rb.preview, p[:min(int64(n), room)]...)
+		}
+	}
+
+	return n, err
+}
+
+// rewind discards the reader of the previous attempt and obtains the one of the next attempt from the factory right away, so that a body that
+// cannot be sent again (ErrBodyNotRewindable) is detected before the attempt is made.
+func (rb *rewindableBody) rewind() error {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.current != nil {
+		_ = rb.current.Close()
+		rb.current = nil
+	}
+
+	// the preview of the previous attempt is kept if there is no next one.
+	rc, err := rb.factory()
+	if err != nil {
+		return err
+	}
+	rb.current = rc
+	rb.size = 0
+	rb.preview = nil
+	return nil
+}
+
+func (rb *rewindableBody) close() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.current != nil {
+		_ = rb.current.Close()
+		rb.current = nil
+	}
+}
+
+// harPostData records the preview of the body actually sent.
+func (rb *rewindableBody) harPostData(mimeType string) *har.PostData {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	pd := &har.PostData{MimeType: mimeType, Data: rb.preview, Params: []har.Param{}}
+	if rb.size > int64(len(rb.preview)) {
+		pd.Comment = fmt.Sprintf("%s: %d of %d bytes", HarContentTruncatedComment, len(rb.preview), rb.size)
+	}
+
+	return pd
+}
@@ -0,0 +1,62 @@
+package restclient_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExecuteStream(t *testing.T) {
+
+	payload := bytes.Repeat([]byte("0123456789"), 20*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		_, _ = w.Write(payload)
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{MaxBodySize: 1024})
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+
+	harEntry, body, err := client.ExecuteStream(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, harEntry.Response.Status)
+
+	b, err := io.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, payload, b)
+
+	require.Equal(t, int64(len(payload)), harEntry.Response.Content.Size)
+	require.Len(t, harEntry.Response.Content.Data, 1024)
+	require.True(t, strings.HasPrefix(harEntry.Response.Content.Comment, restclient.HarContentTruncatedComment))
+	require.NotNil(t, harEntry.Timings)
+
+	// Buffered mode holds up to max-body-size bytes.
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, harEntry.Response.Status)
+	require.Equal(t, payload[:1024], harEntry.Response.Content.Data)
+	require.Equal(t, int64(len(payload)), harEntry.Response.Content.Size)
+	require.Equal(t, "truncated: 1024 of 204800 bytes", harEntry.Response.Content.Comment)
+
+	// a body within the limit is not.
+	client = restclient.NewClient(&restclient.Config{MaxBodySize: int64(len(payload))})
+	defer client.Close()
+
+	harEntry, err = client.Execute(request)
+	require.NoError(t, err)
+	require.Equal(t, payload, harEntry.Response.Content.Data)
+	require.Empty(t, harEntry.Response.Content.Comment)
+}
@@ -0,0 +1,54 @@
+package restclient_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-archive/har"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStreamRequestBody(t *testing.T) {
+
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(strconv.Itoa(len(b))))
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{RetryCount: 3, RetryOnHttpError: []int{http.StatusTooManyRequests}, MaxBodySize: 16})
+	defer client.Close()
+
+	payload := bytes.Repeat([]byte("x"), 100*1024)
+	request, err := client.NewRequest(http.MethodPut, srv.URL, nil, har.NameValuePairs{{Name: "Content-Type", Value: "application/octet-stream"}}, nil)
+	require.NoError(t, err)
+
+	harEntry, err := client.ExecuteContext(context.Background(), request, restclient.ExecutionWithBodyReader(bytes.NewReader(payload)))
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	require.Equal(t, strconv.Itoa(len(payload)), string(harEntry.Response.Content.Data))
+	require.Equal(t, int64(len(payload)), harEntry.Request.BodySize)
+	require.Len(t, harEntry.Request.PostData.Data, 16)
+	require.True(t, strings.HasPrefix(harEntry.Request.PostData.Comment, restclient.HarContentTruncatedComment))
+	require.Nil(t, request.PostData)
+
+	// A plain reader cannot be re-sent: the response of the first attempt is returned.
+	atomic.StoreInt32(&attempts, 0)
+	harEntry, err = client.ExecuteContext(context.Background(), request, restclient.ExecutionWithBodyReader(io.MultiReader(bytes.NewReader(payload))))
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	require.Equal(t, http.StatusTooManyRequests, harEntry.Response.Status)
+	require.Equal(t, int64(len(payload)), harEntry.Request.BodySize)
+}
@@ -0,0 +1,265 @@
+package restclient
+
+import (
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultThrottleLowQuota = 0.1
+	DefaultThrottleMaxDelay = 30 * time.Second
+)
+
+// ThrottleConfig slows the requests down as the server signals its quota is running out. Once the remaining requests in the RateLimit headers
+// drop to LowQuota (a fraction of the limit) the following requests to the host are spread over the time left to the reset; when the quota is
+// exhausted, or a 429/503 comes with a Retry-After, they are held until then. A single delay never exceeds MaxDelay.
+type ThrottleConfig struct {
+	LowQuota float64       `mapstructure:"low-quota,omitempty" json:"low-quota,omitempty" yaml:"low-quota,omitempty"`
+	MaxDelay time.Duration `mapstructure:"max-delay,omitempty" json:"max-delay,omitempty" yaml:"max-delay,omitempty"`
+}
+
+// rateLimitQuota is the quota advertised by the RateLimit headers of a response: limit is -1 if not known.
+type rateLimitQuota struct {
+	limit     int
+	remaining int
+	reset     time.Duration
+}
+
+// parseRateLimitHeaders reads the quota out of the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset fields or out of the combined
+// RateLimit field of the IETF drafts, either as limit=, remaining=, reset= or as r=, t= with the limit in the q= of RateLimit-Policy.
+func parseRateLimitHeaders(h http.Header) (rateLimitQuota, bool) {
+
+	q := rateLimitQuota{limit: -1, remaining: -1, reset: -1}
+
+	params := make(map[string]string)
+	for _, f := range []string{"RateLimit", "RateLimit-Policy"} {
+		for _, p := range strings.FieldsFunc(h.Get(f), func(r rune) bool { return r == ',' || r == ';' }) {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok {
+				params[strings.ToLower(k)] = strings.Trim(v, `"`)
+			}
+		}
+	}
+
+	lookup := func(field string, keys ...string) int {
+		v := h.Get(field)
+		for _, k := range keys {
+			if v != "" {
+				break
+			}
+			v = params[k]
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			return -1
+		}
+		return n
+	}
+
+	q.limit = lookup("RateLimit-Limit", "limit", "q")
+	q.remaining = lookup("RateLimit-Remaining", "remaining", "r")
+	if reset := lookup("RateLimit-Reset", "reset", "t"); reset >= 0 {
+		q.reset = time.Duration(reset) * time.Second
+	}
+
+	return q, q.remaining >= 0 && q.reset >= 0
+}
+
+// parseRetryAfter reads the Retry-After field, either delay seconds or an HTTP-date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(t.Sub(now), 0), true
+}
+
+// retryAfter returns the time a 429 or 503 has to be retried after as asked by the server, if any. Zero leaves the wait to the backoff of the
+// retry policy.
+func retryAfter(resp *resty.Response) time.Duration {
+
+	const semLogContext = "http-client::retry-after"
+
+	if resp.StatusCode() != http.StatusTooManyRequests && resp.StatusCode() != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	d, ok := parseRetryAfter(resp.Header().Get("Retry-After"), time.Now())
+	if !ok {
+		if q, qok := parseRateLimitHeaders(resp.Header()); qok && q.remaining == 0 {
+			d, ok = q.reset, true
+		}
+	}
+
+	if ok {
+		log.Debug().Int("http-status", resp.StatusCode()).Dur("retry-after", d).Msg(semLogContext)
+	}
+
+	return d
+}
+
+// throttle keeps, per host, the earliest time a request can go and the interval between requests while the quota is low.
+type throttle struct {
+	lowQuota float64
+	maxDelay time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostThrottle
+}
+
+type hostThrottle struct {
+	next     time.Time
+	interval time.Duration
+	until    time.Time
+}
+
+func newThrottle(cfg *ThrottleConfig) *throttle {
+	t := &throttle{lowQuota: cfg.LowQuota, maxDelay: cfg.MaxDelay, hosts: make(map[string]*hostThrottle)}
+	if t.lowQuota <= 0 {
+		t.lowQuota = DefaultThrottleLowQuota
+	}
+
+	if t.maxDelay <= 0 {
+		t.maxDelay = DefaultThrottleMaxDelay
+	}
+
+	return t
+}
+
+// observe updates the state of the host out of the headers of a response.
+func (t *throttle) observe(host string, sc int, h http.Header) {
+
+	const semLogContext = "http-client::throttle"
+
+	now := time.Now()
+	var hold time.Duration
+	var interval time.Duration
+
+	q, quotaOk := parseRateLimitHeaders(h)
+	switch {
+	case quotaOk && q.remaining == 0:
+		hold = q.reset
+	case quotaOk && q.limit > 0 && float64(q.remaining) <= t.lowQuota*float64(q.limit):
+		interval = q.reset / time.Duration(q.remaining+1)
+	}
+
+	if sc == http.StatusTooManyRequests || sc == http.StatusServiceUnavailable {
+		if d, ok := parseRetryAfter(h.Get("Retry-After"), now); ok {
+			hold = max(hold, d)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ht, ok := t.hosts[host]
+	if !ok {
+		if hold == 0 && interval == 0 {
+			return
+		}
+		ht = &hostThrottle{}
+		t.hosts[host] = ht
+	}
+
+	if hold > 0 && now.Add(hold).After(ht.next) {
+		ht.next = now.Add(hold)
+		log.Info().Str("host", host).Dur("hold", hold).Msg(semLogContext + " quota exhausted... holding the requests")
+	}
+
+	if quotaOk {
+		if interval > 0 && ht.interval == 0 {
+			log.Info().Str("host", host).Int("remaining", q.remaining).Int("limit", q.limit).Dur("interval", interval).Msg(semLogContext + " quota running low... spacing the requests")
+		}
+		ht.interval = interval
+		ht.until = now.Add(q.reset)
+	}
+}
+
+// delay reserves the slot of a request to host and returns how long it has to wait for it.
+func (t *throttle) delay(host string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ht, ok := t.hosts[host]
+	if !ok {
+		return 0
+	}
+
+	now := time.Now()
+	if ht.interval > 0 && now.After(ht.until) {
+		ht.interval = 0
+	}
+
+	slot := ht.next
+	if slot.Before(now) {
+		slot = now
+	}
+
+	if ht.interval > 0 {
+		ht.next = slot.Add(ht.interval)
+	}
+
+	return min(slot.Sub(now), t.maxDelay)
+}
+
+func requestHost(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+
+	return pu.Host
+}
+
+// waitThrottle is the resty request middleware holding the attempts to a host whose quota is low or exhausted.
+func (s *Client) waitThrottle(_ *resty.Client, req *resty.Request) error {
+
+	const semLogContext = "http-client::throttle"
+
+	d := s.throttle.delay(requestHost(req.URL))
+	if d <= 0 {
+		return nil
+	}
+
+	log.Debug().Dur("wait", d).Str("url", req.URL).Msg(semLogContext + " request delayed")
+
+	ctx := req.Context()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if t, ok := ctx.Value(executionTimingsKey{}).(*harTimingsTrace); ok {
+		t.addBlocked(d)
+	}
+
+	return nil
+}
+
+// observeQuota is the resty response middleware feeding the throttle with the headers of every attempt. Resty skips it for the streamed
+// responses: ExecuteStream feeds the throttle with the last one.
+func (s *Client) observeQuota(_ *resty.Client, resp *resty.Response) error {
+	s.throttle.observe(requestHost(resp.Request.URL), resp.StatusCode(), resp.Header())
+	return nil
+}
@@ -0,0 +1,102 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "seconds", headers: map[string]string{"Retry-After": "5"}},
+		{name: "http-date", headers: map[string]string{"Retry-After": time.Now().Add(5 * time.Second).UTC().Format(http.TimeFormat)}},
+		{name: "ratelimit-reset", headers: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "5"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var n int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt64(&n, 1) == 1 {
+					for k, v := range tc.headers {
+						w.Header().Set(k, v)
+					}
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			defer srv.Close()
+
+			client := restclient.NewClient(&restclient.Config{
+				RetryCount:       1,
+				RetryWaitTime:    10 * time.Millisecond,
+				RetryMaxWaitTime: 300 * time.Millisecond,
+				RetryOnHttpError: []int{http.StatusTooManyRequests},
+			})
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+			require.NoError(t, err)
+
+			// the wait asked by the server is capped by the max retry wait time.
+			start := time.Now()
+			harEntry, err := client.Execute(request)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, harEntry.Response.Status)
+			require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+			require.Less(t, time.Since(start), time.Second)
+		})
+	}
+}
+
+func TestThrottle(t *testing.T) {
+
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		// blocked is the first request expected to be held, the following ones are held as well.
+		blocked int
+	}{
+		{name: "exhausted", headers: map[string]string{"RateLimit": `"default";r=0;t=10`, "RateLimit-Policy": `"default";q=100;w=60`}, blocked: 1},
+		{name: "low", headers: map[string]string{"RateLimit": "limit=100, remaining=5, reset=10"}, blocked: 2},
+		{name: "healthy", headers: map[string]string{"RateLimit-Limit": "100", "RateLimit-Remaining": "50", "RateLimit-Reset": "10"}, blocked: -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tc.headers {
+					w.Header().Set(k, v)
+				}
+			}))
+			defer srv.Close()
+
+			lks, err := restclient.NewInstanceWithConfig(&restclient.Config{Throttle: &restclient.ThrottleConfig{MaxDelay: 200 * time.Millisecond}})
+			require.NoError(t, err)
+
+			for i := 0; i < 3; i++ {
+				client, err := lks.NewClient()
+				require.NoError(t, err)
+
+				request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+				require.NoError(t, err)
+				harEntry, err := client.Execute(request)
+				require.NoError(t, err)
+				client.Close()
+
+				if tc.blocked >= 0 && i >= tc.blocked {
+					require.Greater(t, harEntry.Timings.Blocked, 150.0)
+				} else {
+					require.Less(t, harEntry.Timings.Blocked, 100.0)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	TimeoutPhaseConnection     = "connection"
+	TimeoutPhaseDNS            = "dns"
+	TimeoutPhaseDial           = "dial"
+	TimeoutPhaseTLSHandshake   = "tls-handshake"
+	TimeoutPhaseSend           = "send"
+	TimeoutPhaseExpectContinue = "expect-continue"
+	TimeoutPhaseResponseHeader = "response-header"
+	TimeoutPhaseBody           = "body"
+)
+
+// TimeoutsConfig sets the timeouts of the single phases of a request, on top of the overall timeout of the client. Zero values keep the defaults:
+// DefaultDialTimeout, DefaultTLSHandshakeTimeout, no response header timeout, DefaultExpectContinueTimeout and DefaultIdleConnTimeout.
+// The expect-continue timeout is how long the body is held waiting for a 100 Continue, expiring it sends the body anyway; idle-conn is how long an
+// unused connection is kept in the pool.
+type TimeoutsConfig struct {
+	Dial           time.Duration `mapstructure:"dial,omitempty" json:"dial,omitempty" yaml:"dial,omitempty"`
+	TLSHandshake   time.Duration `mapstructure:"tls-handshake,omitempty" json:"tls-handshake,omitempty" yaml:"tls-handshake,omitempty"`
+	ResponseHeader time.Duration `mapstructure:"response-header,omitempty" json:"response-header,omitempty" yaml:"response-header,omitempty"`
+	ExpectContinue time.Duration `mapstructure:"expect-continue,omitempty" json:"expect-continue,omitempty" yaml:"expect-continue,omitempty"`
+	IdleConn       time.Duration `mapstructure:"idle-conn,omitempty" json:"idle-conn,omitempty" yaml:"idle-conn,omitempty"`
+}
+
+// TimeoutError reports the phase of the request that was in progress when a timeout expired.
+type TimeoutError struct {
+	Phase string
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout in %s phase: %v", e.Phase, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// attemptTimeoutKey is the context key of the timeout of each attempt of an execution: the rest-timeout of the client, unless the execution has
+// a timeout of its own.
+type attemptTimeoutKey struct{}
+
+func withAttemptTimeout(ctx context.Context, to time.Duration) context.Context {
+	return context.WithValue(ctx, attemptTimeoutKey{}, to)
+}
+
+// attemptTimeoutTransport bounds each attempt, from the request to the close of the response body, with the timeout found in its context. It takes
+// the place of the timeout of the http.Client, that cannot be changed per request.
+type attemptTimeoutTransport struct {
+	next http.RoundTripper
+}
+
+func (t *attemptTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	to, ok := req.Context().Value(attemptTimeoutKey{}).(time.Duration)
+	if !ok || to <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), to)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil || resp.Body == nil {
+		cancel()
+		return resp, err
+	}
+
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *attemptTimeoutTransport) CloseIdleConnections() {
+	if ci, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+// cancelOnCloseBody releases the context of the attempt once the body has been consumed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
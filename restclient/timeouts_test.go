@@ -0,0 +1,125 @@
+package restclient_test
+
+import (
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimeoutPhases(t *testing.T) {
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-body" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	// a tls server that never completes the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	var connsMu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		connsMu.Lock()
+		defer connsMu.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			connsMu.Lock()
+			conns = append(conns, conn)
+			connsMu.Unlock()
+		}
+	}()
+
+	testCases := []struct {
+		name     string
+		url      string
+		timeouts *restclient.TimeoutsConfig
+		execTo   time.Duration
+		phase    string
+	}{
+		{name: "response-header", url: srv.URL, timeouts: &restclient.TimeoutsConfig{ResponseHeader: 50 * time.Millisecond}, phase: restclient.TimeoutPhaseResponseHeader},
+		{name: "tls-handshake", url: "https://" + ln.Addr().String(), timeouts: &restclient.TimeoutsConfig{TLSHandshake: 50 * time.Millisecond}, phase: restclient.TimeoutPhaseTLSHandshake},
+		{name: "execution-timeout", url: srv.URL, execTo: 50 * time.Millisecond, phase: restclient.TimeoutPhaseResponseHeader},
+		{name: "execution-timeout-body", url: srv.URL + "/slow-body", execTo: 50 * time.Millisecond, phase: restclient.TimeoutPhaseBody},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := restclient.NewClient(&restclient.Config{Timeouts: tc.timeouts})
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, tc.url, nil, nil, nil)
+			require.NoError(t, err)
+
+			start := time.Now()
+			harEntry, err := client.Execute(request, restclient.ExecutionWithTimeout(tc.execTo))
+			require.Less(t, time.Since(start), 500*time.Millisecond)
+
+			var toErr *restclient.TimeoutError
+			require.True(t, errors.As(err, &toErr), err)
+			require.Equal(t, tc.phase, toErr.Phase)
+			// a timeout reading the body keeps the status received.
+			if tc.phase != restclient.TimeoutPhaseBody {
+				require.Equal(t, http.StatusRequestTimeout, harEntry.Response.Status)
+			}
+
+			spans := tracer.FinishedSpans()
+			require.Equal(t, tc.phase, spans[len(spans)-1].Tag(restclient.TimeoutPhaseTraceTag))
+		})
+	}
+}
+
+func TestExecutionTimeoutOverride(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	client := restclient.NewClient(&restclient.Config{RestTimeout: 100 * time.Millisecond})
+	defer client.Close()
+
+	request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+	require.NoError(t, err)
+
+	// the timeout of the client bounds the attempt.
+	harEntry, err := client.Execute(request)
+	var toErr *restclient.TimeoutError
+	require.True(t, errors.As(err, &toErr), err)
+	require.Equal(t, http.StatusRequestTimeout, harEntry.Response.Status)
+
+	// the timeout of the execution takes its place, longer as it is.
+	harEntry, err = client.Execute(request, restclient.ExecutionWithTimeout(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, harEntry.Response.Status)
+}
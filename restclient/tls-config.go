@@ -0,0 +1,212 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"time"
+)
+
+var ErrTLSConfig = errors.New("invalid tls config")
+
+// TLSConfig configures the TLS connections of the client. The client certificate comes either from the CertFile/KeyFile PEM pair or from a
+// PKCS#12 bundle. CaFiles are PEM bundles of the trusted CAs: they replace the system roots unless IncludeSystemCAs is set.
+type TLSConfig struct {
+	CertFile         string   `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty" yaml:"cert-file,omitempty"`
+	KeyFile          string   `mapstructure:"key-file,omitempty" json:"key-file,omitempty" yaml:"key-file,omitempty"`
+	Pkcs12File       string   `mapstructure:"pkcs12-file,omitempty" json:"pkcs12-file,omitempty" yaml:"pkcs12-file,omitempty"`
+	Pkcs12Password   string   `mapstructure:"pkcs12-password,omitempty" json:"pkcs12-password,omitempty" yaml:"pkcs12-password,omitempty"`
+	CaFiles          []string `mapstructure:"ca-files,omitempty" json:"ca-files,omitempty" yaml:"ca-files,omitempty"`
+	IncludeSystemCAs bool     `mapstructure:"include-system-cas,omitempty" json:"include-system-cas,omitempty" yaml:"include-system-cas,omitempty"`
+	// MinVersion is one of 1.0, 1.1, 1.2, 1.3. Defaults to the Go default (1.2).
+	MinVersion string `mapstructure:"min-version,omitempty" json:"min-version,omitempty" yaml:"min-version,omitempty"`
+	// CipherSuites are IANA names (i.e. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). They do not apply to TLS 1.3.
+	CipherSuites []string `mapstructure:"cipher-suites,omitempty" json:"cipher-suites,omitempty" yaml:"cipher-suites,omitempty"`
+	ServerName   string   `mapstructure:"server-name,omitempty" json:"server-name,omitempty" yaml:"server-name,omitempty"`
+	// Pins are SPKI SHA-256 pins, base64 encoded, optionally prefixed by sha256/. A connection is accepted if its chain contains one of the pinned
+	// keys or of the backup ones.
+	Pins       []string `mapstructure:"pins,omitempty" json:"pins,omitempty" yaml:"pins,omitempty"`
+	BackupPins []string `mapstructure:"backup-pins,omitempty" json:"backup-pins,omitempty" yaml:"backup-pins,omitempty"`
+	// ReloadInterval, if set, enables the hot reload of the certificate and CA files: they are checked for changes at most once per interval.
+	// The connections established before a reload keep the previous material: the requests sent over tcp are tagged with the serial of the
+	// client certificate presented on their connection.
+	ReloadInterval time.Duration `mapstructure:"reload-interval,omitempty" json:"reload-interval,omitempty" yaml:"reload-interval,omitempty"`
+}
+
+// NewTLSClientConfig builds the tls.Config of the client. cfg can be nil, in which case only skipVerify is applied.
+func NewTLSClientConfig(cfg *TLSConfig, skipVerify bool) (*tls.Config, error) {
+	tlsCfg, _, err := newTLSClientConfig(cfg, skipVerify)
+	return tlsCfg, err
+}
+
+// newTLSClientConfig returns the reloader of the certificates as well, if the hot reload is enabled.
+func newTLSClientConfig(cfg *TLSConfig, skipVerify bool) (*tls.Config, *certReloader, error) {
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: skipVerify}
+	if cfg == nil {
+		return tlsCfg, nil, nil
+	}
+
+	tlsCfg.ServerName = cfg.ServerName
+
+	if cfg.MinVersion != "" {
+		v, err := parseTLSVersion(cfg.MinVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.MinVersion = v
+	}
+
+	if len(cfg.CipherSuites) > 0 {
+		ids, err := parseCipherSuites(cfg.CipherSuites)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.CipherSuites = ids
+	}
+
+	pins, err := newPinSet(cfg.Pins, cfg.BackupPins)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var r *certReloader
+	if cfg.ReloadInterval > 0 {
+		if r, err = newCertReloader(cfg); err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.GetClientCertificate = r.getClientCertificate
+	} else {
+		if len(cfg.CaFiles) > 0 {
+			if tlsCfg.RootCAs, err = loadCertPool(cfg.CaFiles, cfg.IncludeSystemCAs); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		cert, err := loadClientCertificate(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if cert != nil {
+			tlsCfg.Certificates = []tls.Certificate{*cert}
+		}
+	}
+
+	// The reloadable CAs cannot be set as RootCAs: the chain is verified in VerifyConnection, with the standard verification disabled.
+	switch {
+	case r != nil && len(cfg.CaFiles) > 0 && !skipVerify:
+		tlsCfg.InsecureSkipVerify = true
+		r.verifyCAs, r.pins = true, pins
+		tlsCfg.VerifyConnection = r.verifyConnection(cfg.ServerName)
+	case pins != nil:
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return pins.check(cs, cs.VerifiedChains)
+		}
+	}
+
+	return tlsCfg, r, nil
+}
+
+// loadClientCertificate returns nil if no client certificate has been configured.
+func loadClientCertificate(cfg *TLSConfig) (*tls.Certificate, error) {
+
+	switch {
+	case cfg.Pkcs12File != "":
+		if cfg.CertFile != "" || cfg.KeyFile != "" {
+			return nil, fmt.Errorf("%w: pkcs12-file and cert-file/key-file are mutually exclusive", ErrTLSConfig)
+		}
+
+		b, err := os.ReadFile(cfg.Pkcs12File)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTLSConfig, err)
+		}
+
+		key, leaf, chain, err := pkcs12.DecodeChain(b, cfg.Pkcs12Password)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrTLSConfig, cfg.Pkcs12File, err)
+		}
+
+		cert := &tls.Certificate{PrivateKey: key, Leaf: leaf, Certificate: [][]byte{leaf.Raw}}
+		for _, c := range chain {
+			cert.Certificate = append(cert.Certificate, c.Raw)
+		}
+		return cert, nil
+
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("%w: both cert-file and key-file are required", ErrTLSConfig)
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTLSConfig, err)
+		}
+		return &cert, nil
+	}
+
+	return nil, nil
+}
+
+func loadCertPool(files []string, includeSystemCAs bool) (*x509.CertPool, error) {
+
+	pool := x509.NewCertPool()
+	if includeSystemCAs {
+		sp, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTLSConfig, err)
+		}
+		pool = sp
+	}
+
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTLSConfig, err)
+		}
+
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%w: no certificates found in %s", ErrTLSConfig, f)
+		}
+	}
+
+	return pool, nil
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(v), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("%w: unsupported tls version %s", ErrTLSConfig, v)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+
+	known := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[cs.Name] = cs.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, n := range names {
+		id, ok := known[strings.ToUpper(n)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown cipher suite %s", ErrTLSConfig, n)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,189 @@
+package restclient_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testCertSerial int64
+
+// testCA issues the certificates of the tls tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&testCertSerial, 1)),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue issues a certificate for the hosts, either dns names or ip addresses.
+func (ca *testCA) issue(t *testing.T, cn string, hosts ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&testCertSerial, 1)),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func writeCertPEM(t *testing.T, fn string, cert *x509.Certificate) {
+	require.NoError(t, os.WriteFile(fn, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+}
+
+func writeKeyPEM(t *testing.T, fn string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fn, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+}
+
+// newMTLSServer starts a tls server presenting a certificate for the host api.internal and requiring a client certificate issued by ca.
+func newMTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	return newMTLSServerFor(t, ca, "api.internal")
+}
+
+func newMTLSServerFor(t *testing.T, ca *testCA, hosts ...string) *httptest.Server {
+	cert, key := ca.issue(t, "server", hosts...)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	srv.StartTLS()
+	return srv
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	srv := newMTLSServer(t, ca)
+	defer srv.Close()
+
+	writeCertPEM(t, filepath.Join(dir, "ca.pem"), ca.cert)
+
+	clientCert, clientKey := ca.issue(t, "pem-client")
+	writeCertPEM(t, filepath.Join(dir, "client.pem"), clientCert)
+	writeKeyPEM(t, filepath.Join(dir, "client-key.pem"), clientKey)
+
+	p12Cert, p12Key := ca.issue(t, "p12-client")
+	pfx, err := pkcs12.Modern.Encode(p12Key, p12Cert, []*x509.Certificate{ca.cert}, "changeit")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.p12"), pfx, 0600))
+
+	testCases := []struct {
+		name     string
+		tls      restclient.TLSConfig
+		expected string
+	}{
+		{name: "pem", tls: restclient.TLSConfig{CertFile: filepath.Join(dir, "client.pem"), KeyFile: filepath.Join(dir, "client-key.pem")}, expected: "pem-client"},
+		{name: "pkcs12", tls: restclient.TLSConfig{Pkcs12File: filepath.Join(dir, "client.p12"), Pkcs12Password: "changeit", MinVersion: "1.3"}, expected: "p12-client"},
+		{name: "no-client-cert"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.tls.CaFiles = []string{filepath.Join(dir, "ca.pem")}
+			tc.tls.ServerName = "api.internal"
+
+			lks, err := restclient.NewInstanceWithConfig(&restclient.Config{TLS: &tc.tls})
+			require.NoError(t, err)
+
+			client, err := lks.NewClient()
+			require.NoError(t, err)
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+			require.NoError(t, err)
+			harEntry, err := client.Execute(request)
+			if tc.expected == "" {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(harEntry.Response.Content.Data))
+		})
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+
+	testCases := []restclient.TLSConfig{
+		{MinVersion: "1.4"},
+		{CipherSuites: []string{"TLS_NOT_A_SUITE"}},
+		{CertFile: "client.pem"},
+		{CaFiles: []string{"not-existent.pem"}},
+	}
+
+	for _, tc := range testCases {
+		_, err := restclient.NewInstanceWithConfig(&restclient.Config{TLS: &tc})
+		require.True(t, errors.Is(err, restclient.ErrTLSConfig), err)
+	}
+
+	tlsCfg, err := restclient.NewTLSClientConfig(&restclient.TLSConfig{MinVersion: "tls1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, false)
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), tlsCfg.MinVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsCfg.CipherSuites)
+}
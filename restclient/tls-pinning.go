@@ -0,0 +1,101 @@
+package restclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+var ErrCertificatePinMismatch = errors.New("server certificate does not match any pin")
+
+// pinSet holds the SPKI SHA-256 pins of a service. The backup pins are accepted as well but a match is logged: it means the server
+// has moved to the backup key and the configuration should be updated.
+type pinSet struct {
+	primary map[[sha256.Size]byte]struct{}
+	backup  map[[sha256.Size]byte]struct{}
+}
+
+func newPinSet(pins []string, backupPins []string) (*pinSet, error) {
+
+	const semLogContext = "http-client::new-pin-set"
+
+	if len(pins) == 0 && len(backupPins) == 0 {
+		return nil, nil
+	}
+
+	if len(backupPins) == 0 {
+		log.Warn().Msg(semLogContext + " no backup pins configured... a rotation of the server key will break the service")
+	}
+
+	var err error
+	p := &pinSet{}
+	if p.primary, err = parsePins(pins); err != nil {
+		return nil, err
+	}
+
+	if p.backup, err = parsePins(backupPins); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// parsePins decodes base64 encoded SHA-256 digests of the subject public key info, optionally prefixed by sha256/ as in the HPKP header.
+func parsePins(pins []string) (map[[sha256.Size]byte]struct{}, error) {
+
+	m := make(map[[sha256.Size]byte]struct{})
+	for _, p := range pins {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(p), "sha256/"))
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%w: invalid pin %s", ErrTLSConfig, p)
+		}
+		m[[sha256.Size]byte(b)] = struct{}{}
+	}
+
+	return m, nil
+}
+
+// SPKIPin returns the pin of a certificate in the format accepted by the pins of the tls config.
+func SPKIPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// check looks for a pinned key in the verified chains. Without verified chains (skv) only the leaf is considered: the handshake proves the
+// server owns its key while any other certificate of the unverified chain could have been appended by anybody.
+func (p *pinSet) check(cs tls.ConnectionState, chains [][]*x509.Certificate) error {
+
+	const semLogContext = "http-client::check-pins"
+
+	var certs []*x509.Certificate
+	for _, chain := range chains {
+		certs = append(certs, chain...)
+	}
+
+	if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+		certs = cs.PeerCertificates[:1]
+	}
+
+	backupMatched := false
+	for _, c := range certs {
+		h := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		if _, ok := p.primary[h]; ok {
+			return nil
+		}
+		if _, ok := p.backup[h]; ok {
+			backupMatched = true
+		}
+	}
+
+	if backupMatched {
+		log.Warn().Str("server-name", cs.ServerName).Msg(semLogContext + " server certificate matched a backup pin")
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrCertificatePinMismatch, cs.ServerName)
+}
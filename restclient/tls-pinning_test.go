@@ -0,0 +1,71 @@
+package restclient_test
+
+import (
+	"errors"
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSPinning(t *testing.T) {
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeCertPEM(t, caFile, srv.Certificate())
+
+	otherCert, _ := newTestCA(t).issue(t, "other")
+	serverPin := restclient.SPKIPin(srv.Certificate())
+	otherPin := restclient.SPKIPin(otherCert)
+
+	testCases := []struct {
+		name       string
+		pins       []string
+		backupPins []string
+		skipVerify bool
+		matches    bool
+	}{
+		{name: "primary", pins: []string{otherPin, serverPin}, matches: true},
+		{name: "backup", pins: []string{otherPin}, backupPins: []string{serverPin}, matches: true},
+		{name: "skv", pins: []string{serverPin}, skipVerify: true, matches: true},
+		{name: "mismatch", pins: []string{otherPin}, backupPins: []string{otherPin}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tlsCfg := &restclient.TLSConfig{Pins: tc.pins, BackupPins: tc.backupPins}
+			if !tc.skipVerify {
+				tlsCfg.CaFiles = []string{caFile}
+			}
+
+			lks, err := restclient.NewInstanceWithConfig(&restclient.Config{TLS: tlsCfg, SkipVerify: tc.skipVerify})
+			require.NoError(t, err)
+
+			client, err := lks.NewClient()
+			require.NoError(t, err)
+			defer client.Close()
+
+			request, err := client.NewRequest(http.MethodGet, srv.URL, nil, nil, nil)
+			require.NoError(t, err)
+			harEntry, err := client.Execute(request)
+			if tc.matches {
+				require.NoError(t, err)
+				require.Equal(t, http.StatusOK, harEntry.Response.Status)
+				return
+			}
+
+			require.True(t, errors.Is(err, restclient.ErrCertificatePinMismatch), err)
+			require.Equal(t, restclient.StatusCertificateError, harEntry.Response.Status)
+			require.Equal(t, restclient.StatusTextCertificatePinMismatch, harEntry.Response.StatusText)
+		})
+	}
+
+	_, err := restclient.NewInstanceWithConfig(&restclient.Config{TLS: &restclient.TLSConfig{Pins: []string{"sha256/not-a-pin"}}})
+	require.True(t, errors.Is(err, restclient.ErrTLSConfig), err)
+}
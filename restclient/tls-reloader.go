@@ -0,0 +1,274 @@
+package restclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tlsMaterial is what gets swapped on a reload: the client certificate and the trusted CAs.
+type tlsMaterial struct {
+	cert   *tls.Certificate
+	serial string
+	roots  *x509.CertPool
+	stamp  string
+}
+
+// certReloader provides the client certificate and verifies the server chain with the material currently on disk. The files are checked at most once
+// every ReloadInterval, when a handshake takes place, and reloaded if their modification time or size changed. A reload that fails, i.e. because the
+// cert and the key files are caught in the middle of a rewrite, keeps the previous material in place and is attempted again at the next check.
+type certReloader struct {
+	cfg TLSConfig
+	// verifyCAs is set when the reloadable CAs replace the standard verification of the server, pins are checked along with it.
+	verifyCAs bool
+	pins      *pinSet
+
+	material  atomic.Pointer[tlsMaterial]
+	mu        sync.Mutex
+	lastCheck time.Time
+}
+
+func newCertReloader(cfg *TLSConfig) (*certReloader, error) {
+
+	const semLogContext = "http-client::new-cert-reloader"
+
+	r := &certReloader{cfg: *cfg, lastCheck: time.Now()}
+	m, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	r.material.Store(m)
+	log.Info().Str("serial", m.serial).Dur("reload-interval", cfg.ReloadInterval).Msg(semLogContext + " tls material loaded")
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	var fs []string
+	if r.cfg.Pkcs12File != "" {
+		fs = append(fs, r.cfg.Pkcs12File)
+	}
+	if r.cfg.CertFile != "" {
+		fs = append(fs, r.cfg.CertFile, r.cfg.KeyFile)
+	}
+	return append(fs, r.cfg.CaFiles...)
+}
+
+// stamp summarizes the state of the files: a change of the stamp triggers a reload.
+func (r *certReloader) stamp() string {
+	var sb strings.Builder
+	for _, f := range r.files() {
+		if fi, err := os.Stat(f); err == nil {
+			_, _ = fmt.Fprintf(&sb, "%s:%d:%d;", f, fi.ModTime().UnixNano(), fi.Size())
+		} else {
+			_, _ = fmt.Fprintf(&sb, "%s:-;", f)
+		}
+	}
+	return sb.String()
+}
+
+func (r *certReloader) load() (*tlsMaterial, error) {
+
+	m := &tlsMaterial{stamp: r.stamp()}
+
+	cert, err := loadClientCertificate(&r.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert != nil {
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrTLSConfig, err)
+			}
+		}
+		m.cert = cert
+		m.serial = cert.Leaf.SerialNumber.Text(16)
+	}
+
+	if len(r.cfg.CaFiles) > 0 {
+		if m.roots, err = loadCertPool(r.cfg.CaFiles, r.cfg.IncludeSystemCAs); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (r *certReloader) current() *tlsMaterial {
+
+	const semLogContext = "http-client::cert-reloader"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur := r.material.Load()
+	if time.Since(r.lastCheck) < r.cfg.ReloadInterval {
+		return cur
+	}
+
+	r.lastCheck = time.Now()
+	if r.stamp() == cur.stamp {
+		return cur
+	}
+
+	m, err := r.load()
+	if err != nil {
+		log.Error().Err(err).Str("serial", cur.serial).Msg(semLogContext + " reload failed... keeping the current tls material")
+		return cur
+	}
+
+	r.material.Store(m)
+	log.Info().Str("serial", m.serial).Str("previous-serial", cur.serial).Msg(semLogContext + " tls material reloaded")
+	return m
+}
+
+func (r *certReloader) getClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+
+	const semLogContext = "http-client::get-client-certificate"
+
+	m := r.current()
+	if m.cert == nil {
+		// no certificate is sent.
+		return &tls.Certificate{}, nil
+	}
+
+	log.Debug().Str("serial", m.serial).Msg(semLogContext)
+	return m.cert, nil
+}
+
+// verifyChains verifies the server chain against the current CAs and the server name. It replaces the standard verification, disabled by
+// InsecureSkipVerify, and is called by VerifyConnection rather than VerifyPeerCertificate because the server name is needed.
+func (r *certReloader) verifyChains(cs tls.ConnectionState, serverName string) ([][]*x509.Certificate, error) {
+	if len(cs.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("tls: server did not present a certificate")
+	}
+
+	if serverName == "" {
+		return nil, fmt.Errorf("tls: no server name to verify the certificate of %s against", cs.PeerCertificates[0].Subject)
+	}
+
+	opts := x509.VerifyOptions{DNSName: serverName, Roots: r.current().roots, Intermediates: x509.NewCertPool()}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+
+	return cs.PeerCertificates[0].Verify(opts)
+}
+
+// verifyConnection returns the VerifyConnection of the connections to serverName. An empty serverName falls back to the SNI of the connection,
+// which is empty for an ip address: the connections dialed by the transport get a config bound to the host dialed (see configForHost).
+func (r *certReloader) verifyConnection(serverName string) func(cs tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		name := serverName
+		if name == "" {
+			name = cs.ServerName
+		}
+
+		chains, err := r.verifyChains(cs, name)
+		if err != nil {
+			return err
+		}
+
+		if r.pins != nil {
+			return r.pins.check(cs, chains)
+		}
+
+		return nil
+	}
+}
+
+// configForHost returns a copy of cfg verifying the server against its ServerName or, if not set, against host.
+func (r *certReloader) configForHost(cfg *tls.Config, host string) *tls.Config {
+	c := cfg.Clone()
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+
+	c.VerifyConnection = r.verifyConnection(c.ServerName)
+	return c
+}
+
+// dialTLSContext dials the https connections of the transport with a config of their own: bound to the host dialed, when the reloadable CAs
+// verify the server, and recording the client certificate presented. The handshake is left to the transport, which traces it; the
+// TLSHandshakeTimeout, applied by the transport to its own dials only, is enforced with a deadline on the connection lifted once the server
+// has been verified.
+func (r *certReloader) dialTLSContext(dialer *net.Dialer, t *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn := &clientCertConn{Conn: raw}
+
+		var cfg *tls.Config
+		if r.verifyCAs {
+			cfg = r.configForHost(t.TLSClientConfig, host)
+		} else {
+			cfg = t.TLSClientConfig.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName = host
+			}
+		}
+
+		cfg.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := r.getClientCertificate(cri)
+			if err == nil && cert.Leaf != nil {
+				conn.serial.Store(cert.Leaf.SerialNumber.Text(16))
+			}
+			return cert, err
+		}
+
+		if d := t.TLSHandshakeTimeout; d > 0 {
+			_ = conn.SetDeadline(time.Now().Add(d))
+			verify := cfg.VerifyConnection
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				_ = conn.SetDeadline(time.Time{})
+				if verify == nil {
+					return nil
+				}
+				return verify(cs)
+			}
+		}
+
+		return tls.Client(conn, cfg), nil
+	}
+}
+
+// clientCertConn is the connection under the tls one dialed by dialTLSContext. It keeps the serial of the client certificate sent on the
+// handshake: after a reload, the connections established before keep presenting the previous certificate.
+type clientCertConn struct {
+	net.Conn
+	serial atomic.Value
+}
+
+// clientCertSerial is the serial number, hex encoded, of the client certificate sent on the handshake of conn. It is empty if no certificate
+// has been sent or the connection has not been dialed by dialTLSContext.
+func clientCertSerial(conn net.Conn) string {
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+
+	cc, ok := tc.NetConn().(*clientCertConn)
+	if !ok {
+		return ""
+	}
+
+	serial, _ := cc.serial.Load().(string)
+	return serial
+}
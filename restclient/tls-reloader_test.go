@@ -0,0 +1,116 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSHotReload(t *testing.T) {
+
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	dir := t.TempDir()
+	tlsCfg := restclient.TLSConfig{
+		CertFile:       filepath.Join(dir, "client.pem"),
+		KeyFile:        filepath.Join(dir, "client-key.pem"),
+		CaFiles:        []string{filepath.Join(dir, "ca.pem")},
+		ServerName:     "api.internal",
+		ReloadInterval: 10 * time.Millisecond,
+	}
+
+	// rotate writes the material of a new ca and returns a server trusting it.
+	rotate := func(cn string) (*httptest.Server, string) {
+		ca := newTestCA(t)
+		cert, key := ca.issue(t, cn)
+		writeCertPEM(t, tlsCfg.CaFiles[0], ca.cert)
+		writeCertPEM(t, tlsCfg.CertFile, cert)
+		writeKeyPEM(t, tlsCfg.KeyFile, key)
+		return newMTLSServer(t, ca), cert.SerialNumber.Text(16)
+	}
+
+	srv, serial := rotate("client-1")
+	defer srv.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{TLS: &tlsCfg})
+	require.NoError(t, err)
+
+	execute := func(u string) string {
+		client, err := lks.NewClient()
+		require.NoError(t, err)
+		defer client.Close()
+
+		request, err := client.NewRequest(http.MethodGet, u, nil, nil, nil)
+		require.NoError(t, err)
+		harEntry, err := client.Execute(request)
+		require.NoError(t, err)
+		return string(harEntry.Response.Content.Data)
+	}
+
+	require.Equal(t, "client-1", execute(srv.URL))
+	spans := tracer.FinishedSpans()
+	require.Equal(t, serial, spans[len(spans)-1].Tag(restclient.TLSClientCertSerialTraceTag))
+
+	// both the client certificate and the trusted ca change: the new server is reachable only with the new material.
+	srv2, serial2 := rotate("client-2")
+	defer srv2.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	require.Equal(t, "client-2", execute(srv2.URL))
+	spans = tracer.FinishedSpans()
+	require.Equal(t, serial2, spans[len(spans)-1].Tag(restclient.TLSClientCertSerialTraceTag))
+
+	// the idle connection to the first server, established before the reload, keeps presenting the previous certificate.
+	require.Equal(t, "client-1", execute(srv.URL))
+	spans = tracer.FinishedSpans()
+	require.Equal(t, serial, spans[len(spans)-1].Tag(restclient.TLSClientCertSerialTraceTag))
+}
+
+func TestTLSHotReloadServerName(t *testing.T) {
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, "client")
+	writeCertPEM(t, filepath.Join(dir, "ca.pem"), ca.cert)
+	writeCertPEM(t, filepath.Join(dir, "client.pem"), cert)
+	writeKeyPEM(t, filepath.Join(dir, "client-key.pem"), key)
+
+	execute := func(u string, serverName string) error {
+		client := restclient.NewClient(&restclient.Config{TLS: &restclient.TLSConfig{
+			CertFile:       filepath.Join(dir, "client.pem"),
+			KeyFile:        filepath.Join(dir, "client-key.pem"),
+			CaFiles:        []string{filepath.Join(dir, "ca.pem")},
+			ServerName:     serverName,
+			ReloadInterval: time.Minute,
+		}})
+		defer client.Close()
+
+		request, err := client.NewRequest(http.MethodGet, u, nil, nil, nil)
+		require.NoError(t, err)
+		_, err = client.Execute(request)
+		return err
+	}
+
+	// the server listens on 127.0.0.1 with a certificate for another host.
+	srv := newMTLSServerFor(t, ca, "other.example")
+	defer srv.Close()
+
+	require.Error(t, execute(srv.URL, ""))
+	require.Error(t, execute(srv.URL, "api.internal"))
+	require.NoError(t, execute(srv.URL, "other.example"))
+
+	// the ip address dialed is verified when no server name is set.
+	srvIP := newMTLSServerFor(t, ca, "127.0.0.1")
+	defer srvIP.Close()
+
+	require.NoError(t, execute(srvIP.URL, ""))
+	require.Error(t, execute(srvIP.URL, "other.example"))
+}
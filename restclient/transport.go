@@ -0,0 +1,188 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"time"
+)
+
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolHTTP2 = "http2"
+	ProtocolH2C   = "h2c"
+
+	DefaultMaxIdleConns          = 100
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultKeepAlive             = 30 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultExpectContinueTimeout = 1 * time.Second
+)
+
+var ErrTransportConfig = errors.New("invalid transport config")
+
+// TransportConfig tunes the connection pool. The pool is owned by the LinkedService and shared by all the clients it creates; a client
+// created on its own gets a pool of its own. Zero values keep the defaults: DefaultMaxIdleConns, GOMAXPROCS+1 idle connections per host,
+// no limit on the connections per host and DefaultKeepAlive. The timeouts of the connections are set in the timeouts section.
+type TransportConfig struct {
+	MaxIdleConns        int `mapstructure:"max-idle-conns,omitempty" json:"max-idle-conns,omitempty" yaml:"max-idle-conns,omitempty"`
+	MaxIdleConnsPerHost int `mapstructure:"max-idle-conns-per-host,omitempty" json:"max-idle-conns-per-host,omitempty" yaml:"max-idle-conns-per-host,omitempty"`
+	MaxConnsPerHost     int `mapstructure:"max-conns-per-host,omitempty" json:"max-conns-per-host,omitempty" yaml:"max-conns-per-host,omitempty"`
+	// KeepAlive is the period of the TCP keep-alive probes, a negative value disables them.
+	KeepAlive time.Duration `mapstructure:"keep-alive,omitempty" json:"keep-alive,omitempty" yaml:"keep-alive,omitempty"`
+	// DisableKeepAlives disables the reuse of the connections: each request gets a new one.
+	DisableKeepAlives bool `mapstructure:"disable-keep-alives,omitempty" json:"disable-keep-alives,omitempty" yaml:"disable-keep-alives,omitempty"`
+	// Protocols are the protocols the transport may use: http1, http2 (over TLS), h2c (cleartext HTTP/2 with prior knowledge, used for the
+	// http urls only if http1 is not listed) and http3. Defaults to http1 and http2, negotiated with ALPN. With http3 the https requests go over
+	// QUIC first and fall back to the other protocols (the defaults if none is listed) when the QUIC connection cannot be established.
+	Protocols []string `mapstructure:"protocols,omitempty" json:"protocols,omitempty" yaml:"protocols,omitempty"`
+}
+
+// transportSetup is the transport built out of the transport, tls and proxy sections of the config, along with the pieces a client needs
+// to trace which certificate and proxy have been used.
+type transportSetup struct {
+	transport   *http.Transport
+	tlsReloader *certReloader
+	proxyFunc   proxyFunc
+
+	// http3 wraps the transport when HTTP/3 is enabled.
+	http3 *http3Transport
+}
+
+func (ts *transportSetup) roundTripper() http.RoundTripper {
+	if ts.http3 != nil {
+		return ts.http3
+	}
+	return ts.transport
+}
+
+func (ts *transportSetup) closeIdleConnections() {
+	if ts.http3 != nil {
+		ts.http3.CloseIdleConnections()
+		return
+	}
+	ts.transport.CloseIdleConnections()
+}
+
+func newTransport(cfg *Config) (*transportSetup, error) {
+
+	tc := TransportConfig{}
+	if cfg.Transport != nil {
+		tc = *cfg.Transport
+	}
+
+	to := TimeoutsConfig{}
+	if cfg.Timeouts != nil {
+		to = *cfg.Timeouts
+	}
+
+	dialer := &net.Dialer{Timeout: DefaultDialTimeout, KeepAlive: DefaultKeepAlive}
+	if tc.KeepAlive != 0 {
+		dialer.KeepAlive = tc.KeepAlive
+	}
+
+	if to.Dial != 0 {
+		dialer.Timeout = to.Dial
+	}
+
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          DefaultMaxIdleConns,
+		MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		IdleConnTimeout:       DefaultIdleConnTimeout,
+		TLSHandshakeTimeout:   DefaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: DefaultExpectContinueTimeout,
+		ResponseHeaderTimeout: to.ResponseHeader,
+		DisableKeepAlives:     tc.DisableKeepAlives,
+	}
+
+	if tc.MaxIdleConns != 0 {
+		t.MaxIdleConns = tc.MaxIdleConns
+	}
+
+	if tc.MaxIdleConnsPerHost != 0 {
+		t.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+
+	if to.IdleConn != 0 {
+		t.IdleConnTimeout = to.IdleConn
+	}
+
+	if to.TLSHandshake != 0 {
+		t.TLSHandshakeTimeout = to.TLSHandshake
+	}
+
+	if to.ExpectContinue != 0 {
+		t.ExpectContinueTimeout = to.ExpectContinue
+	}
+
+	useHttp3 := false
+	if len(tc.Protocols) > 0 {
+		protocols := new(http.Protocols)
+		for _, p := range tc.Protocols {
+			switch p {
+			case ProtocolHTTP3:
+				useHttp3 = true
+			case ProtocolHTTP1:
+				protocols.SetHTTP1(true)
+			case ProtocolHTTP2:
+				protocols.SetHTTP2(true)
+			case ProtocolH2C:
+				protocols.SetUnencryptedHTTP2(true)
+			default:
+				return nil, fmt.Errorf("%w: unsupported protocol %s", ErrTransportConfig, p)
+			}
+		}
+		// http3 alone falls back to the default tcp protocols.
+		if protocols.String() != new(http.Protocols).String() {
+			t.Protocols = protocols
+		}
+	}
+
+	ts := &transportSetup{transport: t}
+
+	switch {
+	case cfg.TLSClientConfig != nil:
+		t.TLSClientConfig = cfg.TLSClientConfig
+	case cfg.TLS != nil:
+		tlsCfg, r, err := newTLSClientConfig(cfg.TLS, cfg.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsCfg
+		ts.tlsReloader = r
+		// VerifyConnection cannot tell the host dialed, the SNI is empty for an ip address, nor a connection which certificate it presented:
+		// the connections get a config of their own.
+		if r != nil {
+			t.DialTLSContext = r.dialTLSContext(dialer, t)
+		}
+	case cfg.SkipVerify:
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if cfg.Proxy != nil {
+		pf, err := newProxyFunc(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Proxy = func(r *http.Request) (*url.URL, error) {
+			return pf(r.URL)
+		}
+		ts.proxyFunc = pf
+	}
+
+	if useHttp3 {
+		ts.http3 = newHttp3Transport(t, t.TLSHandshakeTimeout, ts.tlsReloader)
+	}
+
+	return ts, nil
+}
@@ -0,0 +1,90 @@
+package restclient_test
+
+import (
+	"github.com/GPA-Gruppo-Progetti-Avanzati-SRL/tpm-http-client/restclient"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newConnCountingServer counts the connections opened by the clients.
+func newConnCountingServer(tb testing.TB) (*httptest.Server, *int64) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	return srv, &conns
+}
+
+func executeOnce(tb testing.TB, client *restclient.Client, u string) {
+	request, err := client.NewRequest(http.MethodGet, u, nil, nil, nil)
+	require.NoError(tb, err)
+	harEntry, err := client.Execute(request)
+	require.NoError(tb, err)
+	require.Equal(tb, http.StatusOK, harEntry.Response.Status)
+}
+
+func TestTransportSharedByLinkedService(t *testing.T) {
+
+	srv, conns := newConnCountingServer(t)
+	defer srv.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{Transport: &restclient.TransportConfig{MaxConnsPerHost: 4}})
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		client, err := lks.NewClient()
+		require.NoError(t, err)
+		executeOnce(t, client, srv.URL)
+		client.Close()
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(conns))
+
+	// a client deviating from the transport settings of the linked service gets a pool of its own.
+	client, err := lks.NewClient(restclient.WithTransport(&restclient.TransportConfig{DisableKeepAlives: true}))
+	require.NoError(t, err)
+	defer client.Close()
+	executeOnce(t, client, srv.URL)
+	executeOnce(t, client, srv.URL)
+	require.Equal(t, int64(3), atomic.LoadInt64(conns))
+}
+
+func BenchmarkLinkedServiceClients(b *testing.B) {
+
+	srv, conns := newConnCountingServer(b)
+	defer srv.Close()
+
+	lks, err := restclient.NewInstanceWithConfig(&restclient.Config{})
+	require.NoError(b, err)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, _ := lks.NewClient()
+		executeOnce(b, client, srv.URL)
+		client.Close()
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+}
+
+func BenchmarkStandaloneClients(b *testing.B) {
+
+	srv, conns := newConnCountingServer(b)
+	defer srv.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := restclient.NewClient(&restclient.Config{})
+		executeOnce(b, client, srv.URL)
+		client.Close()
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(conns))/float64(b.N), "conns/op")
+}